@@ -23,15 +23,61 @@ type (
 
 		Janitor struct {
 			Interval time.Duration `long:"interval"    env:"JANITOR_INTERVAL"  description:"Janitor interval (time.duration)"  default:"1h"`
-			Config   string        `long:"config"      env:"JANITOR_CONFIG"    description:"Path to kube-janitor config file" required:"true"`
+			Config   string        `long:"config"      env:"JANITOR_CONFIG"    description:"Path to kube-janitor config file, directory or glob (with includes and ${ENV} expansion)" required:"true"`
 			DryRun   bool          `long:"dry-run"     env:"JANITOR_DRYRUN"    description:"Dry run (no delete)"`
 			Once     bool          `long:"once"        env:"JANITOR_ONCE"      description:"Run once and exit"`
 		}
 
+		// state settings
+		State struct {
+			Backend            string `long:"state.backend"              env:"JANITOR_STATE_BACKEND"              description:"Backend for the persistent state (first-seen and condition-since tracking)" choice:"memory" choice:"boltdb" choice:"configmap" choice:"lease" default:"memory"` // nolint:staticcheck // multiple choices are ok
+			BoltDBPath         string `long:"state.boltdb.path"          env:"JANITOR_STATE_BOLTDB_PATH"          description:"Path to the BoltDB file (state backend boltdb)" default:"kube-janitor.db"`
+			ConfigMapNamespace string `long:"state.configmap.namespace"  env:"JANITOR_STATE_CONFIGMAP_NAMESPACE"  description:"Namespace of the ConfigMap (state backend configmap)" default:"default"`
+			ConfigMapName      string `long:"state.configmap.name"       env:"JANITOR_STATE_CONFIGMAP_NAME"       description:"Name of the ConfigMap (state backend configmap)" default:"kube-janitor-state"`
+			LeaseNamespace     string `long:"state.lease.namespace"      env:"JANITOR_STATE_LEASE_NAMESPACE"      description:"Namespace of the Lease (state backend lease)" default:"default"`
+			LeaseName          string `long:"state.lease.name"           env:"JANITOR_STATE_LEASE_NAME"           description:"Name of the Lease (state backend lease)" default:"kube-janitor-state"`
+		}
+
+		// run report settings
+		Report struct {
+			Backend            string        `long:"report.backend"              env:"JANITOR_REPORT_BACKEND"              description:"Backend for the run reports (disabled if empty)" choice:"" choice:"file" choice:"configmap"` // nolint:staticcheck // multiple choices are ok
+			MaxAge             time.Duration `long:"report.maxage"               env:"JANITOR_REPORT_MAXAGE"               description:"Max age of the run reports which are kept (0 for no limit)" default:"168h"`
+			Keep               int           `long:"report.keep"                 env:"JANITOR_REPORT_KEEP"                 description:"Max number of run reports which are kept (0 for no limit)" default:"0"`
+			FilePath           string        `long:"report.file.path"            env:"JANITOR_REPORT_FILE_PATH"            description:"Path to the JSON-lines file (report backend file)" default:"kube-janitor-reports.jsonl"`
+			ConfigMapNamespace string        `long:"report.configmap.namespace"  env:"JANITOR_REPORT_CONFIGMAP_NAMESPACE"  description:"Namespace of the ConfigMap (report backend configmap)" default:"default"`
+			ConfigMapName      string        `long:"report.configmap.name"       env:"JANITOR_REPORT_CONFIGMAP_NAME"       description:"Name of the ConfigMap (report backend configmap)" default:"kube-janitor-reports"`
+		}
+
+		// audit log settings
+		Audit struct {
+			Stdout         bool   `long:"audit.stdout"             env:"JANITOR_AUDIT_STDOUT"             description:"Write audit records to stdout (logs are written to stderr)"`
+			FilePath       string `long:"audit.file.path"          env:"JANITOR_AUDIT_FILE_PATH"          description:"Path to the audit log file (disabled if empty)"`
+			FileMaxSize    int    `long:"audit.file.maxsize"       env:"JANITOR_AUDIT_FILE_MAXSIZE"       description:"Max size of the audit log file in megabytes before it is rotated" default:"100"`
+			FileMaxBackups int    `long:"audit.file.maxbackups"    env:"JANITOR_AUDIT_FILE_MAXBACKUPS"    description:"Number of rotated audit log files which are kept" default:"10"`
+			HashChain      bool   `long:"audit.hashchain"          env:"JANITOR_AUDIT_HASHCHAIN"          description:"Add the hash of the previous record to every audit record (tamper-evident)"`
+		}
+
+		// metrics settings
+		Metrics struct {
+			Mode       string   `long:"metrics.mode"          env:"JANITOR_METRICS_MODE"          description:"Mode of the per-resource expiry metrics (resource: per resource, aggregated: count per rule/namespace/gvk within 1h/24h/7d, off: disabled)" default:"resource" choice:"resource" choice:"aggregated" choice:"off"` // nolint:staticcheck // multiple choices are ok
+			Namespaces []string `long:"metrics.namespaces"    env:"JANITOR_METRICS_NAMESPACES"    description:"Namespaces with per-resource expiry metrics (independent of the metrics mode)" env-delim:","`
+		}
+
+		// tracing settings
+		Tracing struct {
+			Exporter     string `long:"tracing.exporter"        env:"JANITOR_TRACING_EXPORTER"        description:"OpenTelemetry trace exporter (disabled if empty)" choice:"" choice:"otlp" choice:"stdout"` // nolint:staticcheck // multiple choices are ok
+			OtlpEndpoint string `long:"tracing.otlp.endpoint"   env:"JANITOR_TRACING_OTLP_ENDPOINT"   description:"OTLP http endpoint URL (eg. http://otel-collector:4318, defaults to OTEL_EXPORTER_OTLP_* env vars)"`
+			OtlpInsecure bool   `long:"tracing.otlp.insecure"   env:"JANITOR_TRACING_OTLP_INSECURE"   description:"Disable TLS for the OTLP endpoint"`
+		}
+
 		// kubernetes settings
 		Kubernetes struct {
-			Config       string `long:"kubeconfig"            env:"KUBECONFIG"               description:"Kuberentes config path (should be empty if in-cluster)"`
-			ItemsPerPage int64  `long:"kube.itemsperpage"     env:"KUBE_ITEMSPERPAGE"        description:"Defines how many items per page janitor should process" default:"100"`
+			Config       string   `long:"kubeconfig"            env:"KUBECONFIG"               description:"Kuberentes config path (should be empty if in-cluster)"`
+			Contexts     []string `long:"kube.contexts"         env:"KUBE_CONTEXTS"            description:"Contexts of the kubeconfig for the multi-cluster mode (* for all contexts)" env-delim:","`
+			ConfigDir    string   `long:"kube.configdir"        env:"KUBE_CONFIGDIR"           description:"Directory with kubeconfig files for the multi-cluster mode (one cluster per file)"`
+			ItemsPerPage int64    `long:"kube.itemsperpage"     env:"KUBE_ITEMSPERPAGE"        description:"Defines how many items per page janitor should process" default:"100"`
+
+			DiscoveryRefresh time.Duration `long:"kube.discovery.refresh"  env:"KUBE_DISCOVERY_REFRESH"  description:"Refresh interval of the discovered api groups and resources (also refreshed on CRD create/delete)" default:"1h"`
 		}
 
 		// general options
@@ -40,6 +86,8 @@ type (
 			Bind         string        `long:"server.bind"              env:"SERVER_BIND"           description:"Server address"        default:":8080"`
 			ReadTimeout  time.Duration `long:"server.timeout.read"      env:"SERVER_TIMEOUT_READ"   description:"Server read timeout"   default:"5s"`
 			WriteTimeout time.Duration `long:"server.timeout.write"     env:"SERVER_TIMEOUT_WRITE"  description:"Server write timeout"  default:"10s"`
+
+			HealthIntervalMultiplier float64 `long:"server.health.intervalmultiplier"  env:"SERVER_HEALTH_INTERVALMULTIPLIER"  description:"Liveness fails if no run finished within this multiple of --interval" default:"3"`
 		}
 	}
 )
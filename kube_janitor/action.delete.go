@@ -0,0 +1,38 @@
+package kube_janitor
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type (
+	// janitorActionDelete deletes the resource
+	janitorActionDelete struct{}
+)
+
+func (a *janitorActionDelete) Name() string {
+	return ActionTypeDelete
+}
+
+func (a *janitorActionDelete) EventAction() string {
+	return KubeEventActionDeleted
+}
+
+func (a *janitorActionDelete) Description() string {
+	return "resource is being deleted"
+}
+
+// Execute deletes the resource with the delete options of the rule
+func (a *janitorActionDelete) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	deleteOpts := rule.DeleteOptions.AsDeleteOptions()
+	err := j.traceDelete(ctx, gvr, resource.GetNamespace(), resource.GetName(), func(ctx context.Context) error {
+		return j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Delete(ctx, resource.GetName(), deleteOpts)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
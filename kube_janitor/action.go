@@ -0,0 +1,100 @@
+package kube_janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	ActionTypeDelete      = "delete"
+	ActionTypeScaleToZero = "scaleToZero"
+	ActionTypeSuspend     = "suspend"
+	ActionTypePatch       = "patch"
+	ActionTypeAnnotate    = "annotate"
+	ActionTypeLabel       = "label"
+)
+
+var (
+	errActionNotSupported = errors.New("action is not supported for this resource")
+
+	// errActionPostponed is returned if a precondition of the action is not met yet, the action is retried by the next run
+	errActionPostponed = errors.New("action is postponed")
+)
+
+type (
+	// JanitorAction is the action which is executed for expired resources
+	JanitorAction interface {
+		// Name returns the name of the action (as used in the config)
+		Name() string
+
+		// EventAction returns the action for Kubernetes Events
+		EventAction() string
+
+		// Description returns the description of the action for logs and Kubernetes Events
+		Description() string
+
+		// Execute executes the action for the resource, returns false if the resource was already processed
+		Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error)
+	}
+
+	// janitorActionAppliedChecker is implemented by actions which keep the resource, it checks if the action
+	// is already applied (eg. already scaled to zero) so the two-phase approval doesn't mark the resource again
+	janitorActionAppliedChecker interface {
+		Applied(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured) (bool, error)
+	}
+
+	// configRuleActionRaw is used to parse the object representation of the ConfigRuleAction
+	configRuleActionRaw ConfigRuleAction
+)
+
+// newJanitorAction creates the action implementation from the config
+func newJanitorAction(config *ConfigRuleAction) (JanitorAction, error) {
+	switch config.Type {
+	case "", ActionTypeDelete:
+		return &janitorActionDelete{}, nil
+	case ActionTypeScaleToZero:
+		return &janitorActionScaleToZero{}, nil
+	case ActionTypeSuspend:
+		return &janitorActionSuspend{}, nil
+	case ActionTypePatch:
+		return newJanitorActionPatch(config.PatchType, config.Patch)
+	case ActionTypeAnnotate:
+		if len(config.Annotations) == 0 {
+			return nil, errors.New("action annotate requires at least one annotation")
+		}
+		return &janitorActionMetadata{field: "annotations", values: config.Annotations}, nil
+	case ActionTypeLabel:
+		if len(config.Labels) == 0 {
+			return nil, errors.New("action label requires at least one label")
+		}
+		return &janitorActionMetadata{field: "labels", values: config.Labels}, nil
+	default:
+		return nil, fmt.Errorf(
+			`action must be %s`,
+			strings.Join([]string{ActionTypeDelete, ActionTypeScaleToZero, ActionTypeSuspend, ActionTypePatch, ActionTypeAnnotate, ActionTypeLabel}, ", "),
+		)
+	}
+}
+
+// UnmarshallConfigRuleAction parses the action either from a string (action type) or from an object (action type with options)
+func UnmarshallConfigRuleAction(ctx context.Context, action *ConfigRuleAction, data []byte) error {
+	var valString string
+	if err := yaml.UnmarshalContext(ctx, data, &valString, yaml.Strict()); err == nil {
+		action.Type = strings.TrimSpace(valString)
+		return nil
+	}
+
+	raw := configRuleActionRaw{}
+	if err := yaml.UnmarshalContext(ctx, data, &raw, yaml.Strict()); err != nil {
+		return fmt.Errorf(`failed to parse action: %w`, err)
+	}
+
+	*action = ConfigRuleAction(raw)
+	return nil
+}
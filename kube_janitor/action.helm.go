@@ -0,0 +1,93 @@
+package kube_janitor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	ActionTypeHelmUninstall = "helmUninstall"
+)
+
+type (
+	// janitorActionHelmUninstall deletes all manifest objects of a helm release and its release secrets (history),
+	// only used by helm rules (resource is the latest release secret)
+	janitorActionHelmUninstall struct{}
+)
+
+func (a *janitorActionHelmUninstall) Name() string {
+	return ActionTypeHelmUninstall
+}
+
+func (a *janitorActionHelmUninstall) EventAction() string {
+	return "Uninstalled"
+}
+
+func (a *janitorActionHelmUninstall) Description() string {
+	return "helm release is being uninstalled"
+}
+
+// Execute deletes the manifest objects (reverse manifest order) and afterwards all release secrets,
+// the release secrets are kept if an object could not be deleted so the next run can retry
+func (a *janitorActionHelmUninstall) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	release, err := decodeHelmRelease(resource)
+	if err != nil {
+		return false, err
+	}
+
+	objects, err := helmReleaseManifestObjects(release)
+	if err != nil {
+		return false, err
+	}
+
+	logger := j.logger.With(
+		slog.String("rule", rule.Id),
+		slog.String("namespace", resource.GetNamespace()),
+		slog.String("release", release.Name),
+	)
+
+	var errs []error
+	for _, obj := range slices.Backward(objects) {
+		deleted, err := j.kubeDeleteHelmReleaseObject(ctx, logger, release, obj, rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if deleted {
+			logger.Debug("deleted helm release object", slog.String("object", obj.GetKind()+"/"+obj.GetName()))
+		}
+	}
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+
+	// release history
+	selector := ConfigLabelSelector{}
+	selector.MatchLabels = map[string]string{
+		HelmReleaseLabelOwner: HelmReleaseOwner,
+		HelmReleaseLabelName:  resource.GetLabels()[HelmReleaseLabelName],
+	}
+
+	var releaseSecrets []string
+	err = j.kubeEachResource(ctx, gvr, resource.GetNamespace(), selector, KubeNoFieldSelector, func(item unstructured.Unstructured) error {
+		releaseSecrets = append(releaseSecrets, item.GetName())
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range releaseSecrets {
+		if _, err := j.kubeDeleteIfExists(ctx, gvr, resource.GetNamespace(), name, rule); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
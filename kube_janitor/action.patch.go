@@ -0,0 +1,168 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ActionPatchTypeMerge     = "merge"
+	ActionPatchTypeJson      = "json"
+	ActionPatchTypeStrategic = "strategic"
+)
+
+type (
+	// janitorActionPatch patches the resource with a JSON, merge or strategic merge patch
+	janitorActionPatch struct {
+		patchType types.PatchType
+		patch     []byte
+	}
+
+	// janitorActionMetadata sets annotations or labels on the resource
+	janitorActionMetadata struct {
+		field  string
+		values map[string]string
+	}
+)
+
+// newJanitorActionPatch creates the patch action, the patch can be written as JSON or YAML
+func newJanitorActionPatch(patchType, patch string) (*janitorActionPatch, error) {
+	if patch == "" {
+		return nil, errors.New("action patch requires a patch")
+	}
+
+	patchData, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return nil, fmt.Errorf(`unable to parse patch: %w`, err)
+	}
+
+	action := &janitorActionPatch{patch: patchData}
+
+	switch patchType {
+	case "", ActionPatchTypeMerge:
+		action.patchType = types.MergePatchType
+	case ActionPatchTypeStrategic:
+		action.patchType = types.StrategicMergePatchType
+	case ActionPatchTypeJson:
+		action.patchType = types.JSONPatchType
+
+		var operations []map[string]interface{}
+		if err := json.Unmarshal(patchData, &operations); err != nil {
+			return nil, fmt.Errorf(`json patch must be a list of operations: %w`, err)
+		}
+	default:
+		return nil, fmt.Errorf(`patchType must be %s, %s or %s`, ActionPatchTypeMerge, ActionPatchTypeJson, ActionPatchTypeStrategic)
+	}
+
+	return action, nil
+}
+
+func (a *janitorActionPatch) Name() string {
+	return ActionTypePatch
+}
+
+func (a *janitorActionPatch) EventAction() string {
+	return "Patched"
+}
+
+func (a *janitorActionPatch) Description() string {
+	return "resource is being patched"
+}
+
+// Execute patches the resource, a patch which doesn't change the resource (same resourceVersion) is treated as already processed
+func (a *janitorActionPatch) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	result, err := j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), a.patchType, a.patch, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	return result.GetResourceVersion() != resource.GetResourceVersion(), nil
+}
+
+// Applied checks with a dry-run patch if the patch would change the resource
+func (a *janitorActionPatch) Applied(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured) (bool, error) {
+	result, err := j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), a.patchType, a.patch, metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}, FieldManager: KubeFieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	// metadata maintained by the api server is changed by every (dry-run) write
+	current := resource.DeepCopy()
+	patched := result.DeepCopy()
+	for _, obj := range []*unstructured.Unstructured{current, patched} {
+		obj.SetManagedFields(nil)
+		obj.SetResourceVersion("")
+		obj.SetGeneration(0)
+	}
+
+	return equality.Semantic.DeepEqual(current.Object, patched.Object), nil
+}
+
+func (a *janitorActionMetadata) Name() string {
+	if a.field == "labels" {
+		return ActionTypeLabel
+	}
+	return ActionTypeAnnotate
+}
+
+func (a *janitorActionMetadata) EventAction() string {
+	if a.field == "labels" {
+		return "Labeled"
+	}
+	return "Annotated"
+}
+
+func (a *janitorActionMetadata) Description() string {
+	return fmt.Sprintf("%s are being set on resource", a.field)
+}
+
+// Execute sets the annotations or labels on the resource (if not already set)
+func (a *janitorActionMetadata) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	// check if all values are already set
+	if alreadySet, _ := a.Applied(ctx, j, gvr, resource); alreadySet {
+		return false, nil
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			a.field: maps.Clone(a.values),
+		},
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), types.MergePatchType, patchData, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Applied checks if all annotations or labels are already set on the resource
+func (a *janitorActionMetadata) Applied(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured) (bool, error) {
+	current := resource.GetAnnotations()
+	if a.field == "labels" {
+		current = resource.GetLabels()
+	}
+
+	for key, value := range a.values {
+		if currentValue, exists := current[key]; !exists || currentValue != value {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	ActionScaleToZeroAnnotationReplicas = "janitor/previous-replicas"
+)
+
+var (
+	actionScaleToZeroResources = []string{"deployments", "statefulsets", "replicasets"}
+)
+
+type (
+	// janitorActionScaleToZero scales the resource to zero replicas and stores the previous replicas as annotation
+	janitorActionScaleToZero struct{}
+)
+
+func (a *janitorActionScaleToZero) Name() string {
+	return ActionTypeScaleToZero
+}
+
+func (a *janitorActionScaleToZero) EventAction() string {
+	return "Scaled"
+}
+
+func (a *janitorActionScaleToZero) Description() string {
+	return "resource is being scaled to zero"
+}
+
+// Execute scales Deployments, StatefulSets and ReplicaSets to zero replicas
+func (a *janitorActionScaleToZero) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	replicas, err := a.replicas(gvr, resource)
+	if err != nil {
+		return false, err
+	}
+
+	// already scaled down
+	if replicas == 0 {
+		return false, nil
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				ActionScaleToZeroAnnotationReplicas: strconv.FormatInt(replicas, 10),
+			},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 0,
+		},
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), types.MergePatchType, patchData, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Applied checks if the resource is already scaled to zero
+func (a *janitorActionScaleToZero) Applied(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured) (bool, error) {
+	replicas, err := a.replicas(gvr, resource)
+	if err != nil {
+		return false, err
+	}
+
+	return replicas == 0, nil
+}
+
+// replicas returns the current replicas of the resource
+func (a *janitorActionScaleToZero) replicas(gvr schema.GroupVersionResource, resource unstructured.Unstructured) (int64, error) {
+	if gvr.Group != "apps" || !slices.Contains(actionScaleToZeroResources, gvr.Resource) {
+		return 0, errActionNotSupported
+	}
+
+	replicas, exists, err := unstructured.NestedInt64(resource.Object, "spec", "replicas")
+	if err != nil {
+		return 0, err
+	}
+
+	// replicas defaults to 1 if not set
+	if !exists {
+		replicas = 1
+	}
+
+	return replicas, nil
+}
@@ -0,0 +1,36 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type (
+	// janitorActionSnapshotDelete creates a VolumeSnapshot of the PersistentVolumeClaim and deletes the claim
+	// once the snapshot is ready to use (postponed to the next run until then)
+	janitorActionSnapshotDelete struct {
+		janitorActionDelete
+		config *ConfigVolumeSnapshot
+	}
+)
+
+func (a *janitorActionSnapshotDelete) Description() string {
+	return "volume snapshot is ready to use and resource is being deleted"
+}
+
+// Execute creates the VolumeSnapshot (if not exists) and deletes the resource if the snapshot is ready to use
+func (a *janitorActionSnapshotDelete) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	ready, err := j.kubeSnapshotVolumeClaim(ctx, rule, a.config, resource)
+	if err != nil {
+		return false, err
+	}
+
+	if !ready {
+		return false, fmt.Errorf(`%w: volume snapshot "%s" is not yet ready to use`, errActionPostponed, volumeSnapshotName(resource))
+	}
+
+	return a.janitorActionDelete.Execute(ctx, j, gvr, resource, rule)
+}
@@ -0,0 +1,58 @@
+package kube_janitor
+
+import (
+	"context"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	actionSuspendResources = []string{"cronjobs", "jobs"}
+)
+
+type (
+	// janitorActionSuspend suspends CronJobs and Jobs
+	janitorActionSuspend struct{}
+)
+
+func (a *janitorActionSuspend) Name() string {
+	return ActionTypeSuspend
+}
+
+func (a *janitorActionSuspend) EventAction() string {
+	return "Suspended"
+}
+
+func (a *janitorActionSuspend) Description() string {
+	return "resource is being suspended"
+}
+
+// Execute sets spec.suspend for CronJobs and Jobs
+func (a *janitorActionSuspend) Execute(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	// already suspended
+	if suspended, err := a.Applied(ctx, j, gvr, resource); err != nil || suspended {
+		return false, err
+	}
+
+	patchData := []byte(`{"spec":{"suspend":true}}`)
+	_, err := j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), types.MergePatchType, patchData, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Applied checks if the resource is already suspended
+func (a *janitorActionSuspend) Applied(ctx context.Context, j *Janitor, gvr schema.GroupVersionResource, resource unstructured.Unstructured) (bool, error) {
+	if gvr.Group != "batch" || !slices.Contains(actionSuspendResources, gvr.Resource) {
+		return false, errActionNotSupported
+	}
+
+	suspended, _, _ := unstructured.NestedBool(resource.Object, "spec", "suspend")
+	return suspended, nil
+}
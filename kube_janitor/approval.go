@@ -0,0 +1,236 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// isResourceKept checks if the resource (or namespace) is protected by the keep annotation
+func (j *Janitor) isResourceKept(resource metav1.Object) bool {
+	val, exists := resource.GetAnnotations()[j.config.Annotations.Keep]
+	if !exists {
+		return false
+	}
+
+	// invalid values are treated as keep, better safe than sorry
+	if keep, err := strconv.ParseBool(val); err == nil {
+		return keep
+	}
+
+	return true
+}
+
+// checkResourceApproval handles the two-phase (mark then execute) approval for expired resources,
+// returns true if the resource was marked longer than the approval delay and the action can be executed
+//
+// states:
+//   - not marked:                 resource is marked (annotation), Event and notification are sent
+//   - marked, delay not reached:  waiting for confirmation delay
+//   - marked, delay reached:      action can be executed
+//   - mark removed by owner:      cancelled (annotation), the resource is not marked again
+func (j *Janitor) checkResourceApproval(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule, ttlValue string, expirationDate time.Time) (bool, error) {
+	stateKey := approvalStateKey(resource)
+	annotations := resource.GetAnnotations()
+
+	// cancellation is stored on the resource, it survives restarts and is independent of the state backend
+	if cancelledAt, cancelled := annotations[j.config.Annotations.DeletionCancelled]; cancelled {
+		logger.Debug("action was cancelled by the owner", slog.String("cancelledAt", cancelledAt))
+		return false, nil
+	}
+
+	markValue, marked := annotations[j.config.Annotations.MarkedForDeletion]
+	if !marked {
+		// mark was set by the janitor but is removed now, action was cancelled by the owner
+		if markedAt, tracked := j.conditionGet(stateKey); tracked {
+			return false, j.cancelResourceApproval(ctx, logger, resourceConfig, resource, rule, markedAt)
+		}
+
+		return false, j.markResourceForDeletion(ctx, logger, resourceConfig, resource, rule, ttlValue, expirationDate)
+	}
+
+	// the janitor only marks expired resources, a mark before the expiry or in the future
+	// was not set by this approval (eg. copied with the resource or edited) and is not trusted
+	markedAt := j.parseTimestamp(markValue)
+	if markedAt == nil || markedAt.Before(expirationDate.Truncate(time.Second)) || markedAt.After(time.Now()) {
+		logger.Warn("invalid mark for deletion, marking resource again", slog.String("mark", markValue), slog.Time("expirationDate", expirationDate))
+		return false, j.markResourceForDeletion(ctx, logger, resourceConfig, resource, rule, ttlValue, expirationDate)
+	}
+
+	// a mark which was moved to an earlier timestamp doesn't shorten the delay
+	if trackedAt, tracked := j.conditionGet(stateKey); tracked && trackedAt.After(*markedAt) {
+		markedAt = &trackedAt
+	}
+
+	j.conditionSet(stateKey, *markedAt)
+
+	if approvalDate := markedAt.Add(rule.Approval.delay); time.Now().Before(approvalDate) {
+		logger.Debug("resource is marked for deletion, waiting for confirmation delay", slog.Time("markedAt", *markedAt), slog.Time("approvalDate", approvalDate))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// markResourceForDeletion stamps the mark annotation on the resource and sends an Event and notification
+func (j *Janitor) markResourceForDeletion(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule, ttlValue string, expirationDate time.Time) error {
+	markAnnotation := j.config.Annotations.MarkedForDeletion
+	action := rule.GetAction()
+	now := time.Now()
+	approvalDate := now.Add(rule.Approval.delay)
+
+	if j.dryRun {
+		logger.Info("resource is expired, would mark resource for deletion (DRY-RUN)", slog.Time("expirationDate", expirationDate))
+		j.audit(rule.Id, AuditActionMarkForDeletion, resource, ttlValue, &expirationDate, false, nil)
+		return nil
+	}
+
+	logger.Info("resource is expired, marking resource for deletion", slog.Time("expirationDate", expirationDate), slog.Time("approvalDate", approvalDate))
+	markValue := now.Format(time.RFC3339)
+	err := j.kubePatchResourceAnnotations(ctx, resourceConfig.AsGVR(), resource, map[string]*string{markAnnotation: &markValue})
+	j.audit(rule.Id, AuditActionMarkForDeletion, resource, ttlValue, &expirationDate, err == nil, err)
+	if err != nil {
+		return err
+	}
+	j.conditionSet(approvalStateKey(resource), now)
+
+	reason := "MarkedForDeletion"
+	message := fmt.Sprintf(
+		`TTL of "%v" is expired and resource is marked for %s after %s, remove annotation "%s" or set "%s" to cancel (%s)`,
+		ttlValue,
+		action.Name(),
+		approvalDate.Format(time.RFC3339),
+		markAnnotation,
+		j.config.Annotations.Keep,
+		rule.Id,
+	)
+
+	if err := j.kubeCreateEventFromResource(ctx, resource.GetNamespace(), resource, "Marked", message, reason); err != nil {
+		logger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+	}
+
+	notification := newNotificationMessage(rule, resource, reason, message, expirationDate)
+	notification.ActionDate = approvalDate
+	if err := j.sendNotification(ctx, rule.Notification, notification); err != nil {
+		logger.Error("unable to send notification", slog.Any("error", err))
+	}
+
+	return nil
+}
+
+// cancelResourceApproval stores the cancellation (mark was removed by the owner) as annotation on the resource
+func (j *Janitor) cancelResourceApproval(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule, markedAt time.Time) error {
+	cancelledAnnotation := j.config.Annotations.DeletionCancelled
+	action := rule.GetAction()
+
+	if j.dryRun {
+		logger.Info("mark for deletion was removed, would cancel action (DRY-RUN)", slog.Time("markedAt", markedAt))
+		j.audit(rule.Id, AuditActionCancelDeletion, resource, "", nil, false, nil)
+		return nil
+	}
+
+	logger.Info("mark for deletion was removed, cancelled action", slog.Time("markedAt", markedAt))
+	cancelledValue := time.Now().Format(time.RFC3339)
+	err := j.kubePatchResourceAnnotations(ctx, resourceConfig.AsGVR(), resource, map[string]*string{cancelledAnnotation: &cancelledValue})
+	j.audit(rule.Id, AuditActionCancelDeletion, resource, "", nil, err == nil, err)
+	if err != nil {
+		return err
+	}
+	j.conditionDelete(approvalStateKey(resource))
+
+	message := fmt.Sprintf(
+		`mark "%s" was removed, %s was cancelled, remove annotation "%s" to enable the janitor again (%s)`,
+		j.config.Annotations.MarkedForDeletion,
+		action.Name(),
+		cancelledAnnotation,
+		rule.Id,
+	)
+	if err := j.kubeCreateEventFromResource(ctx, resource.GetNamespace(), resource, "Cancelled", message, "DeletionCancelled"); err != nil {
+		logger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+	}
+
+	return nil
+}
+
+// finishResourceApproval removes the mark after the action was executed, resources which are kept by the action
+// (eg. scaleToZero) need a new approval if they expire again (eg. after they were scaled up by the owner)
+func (j *Janitor) finishResourceApproval(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, action JanitorAction) error {
+	j.conditionDelete(approvalStateKey(resource))
+
+	// resource is removed by the action
+	if _, keepsResource := action.(janitorActionAppliedChecker); !keepsResource {
+		return nil
+	}
+
+	markAnnotation := j.config.Annotations.MarkedForDeletion
+	if _, marked := resource.GetAnnotations()[markAnnotation]; !marked {
+		return nil
+	}
+
+	logger.Debug("action was executed, removing mark for deletion")
+	return j.kubePatchResourceAnnotations(ctx, resourceConfig.AsGVR(), resource, map[string]*string{markAnnotation: nil})
+}
+
+// resetResourceApproval removes the mark for deletion and the cancellation (eg. if the resource is not expired anymore or is kept)
+func (j *Janitor) resetResourceApproval(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured) error {
+	j.conditionDelete(approvalStateKey(resource))
+
+	annotations := map[string]*string{}
+	for _, annotation := range []string{j.config.Annotations.MarkedForDeletion, j.config.Annotations.DeletionCancelled} {
+		if _, exists := resource.GetAnnotations()[annotation]; exists {
+			annotations[annotation] = nil
+		}
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	if j.dryRun {
+		logger.Info("resource is not expired (anymore), would remove mark for deletion (DRY-RUN)")
+		return nil
+	}
+
+	logger.Info("resource is not expired (anymore), removing mark for deletion")
+	return j.kubePatchResourceAnnotations(ctx, resourceConfig.AsGVR(), resource, annotations)
+}
+
+// isActionApplied checks if the action is already applied to the resource (only actions which keep the resource)
+func (j *Janitor) isActionApplied(ctx context.Context, resourceConfig *ConfigResource, resource unstructured.Unstructured, action JanitorAction) (bool, error) {
+	checker, ok := action.(janitorActionAppliedChecker)
+	if !ok {
+		return false, nil
+	}
+
+	return checker.Applied(ctx, j, resourceConfig.AsGVR(), resource)
+}
+
+// approvalStateKey returns the state key of the tracked mark of a resource
+func approvalStateKey(resource unstructured.Unstructured) string {
+	return "approval." + string(resource.GetUID())
+}
+
+// kubePatchResourceAnnotations sets annotations on a resource (or removes them if the value is nil)
+func (j *Janitor) kubePatchResourceAnnotations(ctx context.Context, gvr schema.GroupVersionResource, resource unstructured.Unstructured, annotations map[string]*string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.dynClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), types.MergePatchType, patchData, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	return err
+}
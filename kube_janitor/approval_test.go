@@ -0,0 +1,296 @@
+package kube_janitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type (
+	// testApprovalStep is one janitor run of an approval test
+	testApprovalStep struct {
+		name string
+
+		// before changes the resource before the run (eg. owner removes the mark)
+		before func(resource *unstructured.Unstructured)
+
+		// restart resets the state store before the run (memory backend)
+		restart bool
+
+		// notExpired runs with a ttl which is not expired
+		notExpired bool
+
+		wantExists    bool
+		wantMarked    bool
+		wantCancelled bool
+	}
+)
+
+func TestResourceApproval(t *testing.T) {
+	removeAnnotation := func(annotation string) func(resource *unstructured.Unstructured) {
+		return func(resource *unstructured.Unstructured) {
+			annotations := resource.GetAnnotations()
+			delete(annotations, annotation)
+			resource.SetAnnotations(annotations)
+		}
+	}
+
+	setAnnotation := func(annotation, value string) func(resource *unstructured.Unstructured) {
+		return func(resource *unstructured.Unstructured) {
+			annotations := resource.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[annotation] = value
+			resource.SetAnnotations(annotations)
+		}
+	}
+
+	testCases := []struct {
+		name  string
+		delay string
+		steps []testApprovalStep
+	}{
+		{
+			name:  "mark, wait and delete",
+			delay: "0s",
+			steps: []testApprovalStep{
+				{name: "mark", wantExists: true, wantMarked: true},
+				{name: "delete", wantExists: false},
+			},
+		},
+		{
+			name:  "mark and wait for delay",
+			delay: "1h",
+			steps: []testApprovalStep{
+				{name: "mark", wantExists: true, wantMarked: true},
+				{name: "wait", wantExists: true, wantMarked: true},
+				{name: "wait after restart", restart: true, wantExists: true, wantMarked: true},
+			},
+		},
+		{
+			name:  "mark removed cancels",
+			delay: "0s",
+			steps: []testApprovalStep{
+				{name: "mark", wantExists: true, wantMarked: true},
+				{name: "cancel", before: removeAnnotation(AnnotationDefaultMarkedForDeletion), wantExists: true, wantCancelled: true},
+				{name: "cancelled after restart", restart: true, wantExists: true, wantCancelled: true},
+				{name: "enabled again", before: removeAnnotation(AnnotationDefaultDeletionCancelled), wantExists: true, wantMarked: true},
+			},
+		},
+		{
+			name:  "keep annotation removes mark",
+			delay: "0s",
+			steps: []testApprovalStep{
+				{name: "mark", wantExists: true, wantMarked: true},
+				{name: "keep", before: setAnnotation(AnnotationDefaultKeep, "true"), wantExists: true},
+				{name: "still kept", wantExists: true},
+			},
+		},
+		{
+			name:  "reset if not expired anymore",
+			delay: "0s",
+			steps: []testApprovalStep{
+				{name: "mark", wantExists: true, wantMarked: true},
+				{name: "reset", notExpired: true, wantExists: true},
+				{name: "mark again", wantExists: true, wantMarked: true},
+			},
+		},
+		{
+			name:  "mark before expiry is not trusted",
+			delay: "0s",
+			steps: []testApprovalStep{
+				{
+					name:       "mark again",
+					before:     setAnnotation(AnnotationDefaultMarkedForDeletion, time.Now().Add(-24*time.Hour).Format(time.RFC3339)),
+					wantExists: true,
+					wantMarked: true,
+				},
+				{name: "delete", wantExists: false},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			resource := &unstructured.Unstructured{}
+			resource.SetAPIVersion("v1")
+			resource.SetKind("ConfigMap")
+			resource.SetNamespace("test")
+			resource.SetName("config")
+			resource.SetUID("9f8e7d6c-5b4a")
+
+			j := newTestJanitor(t, resource)
+			resourceConfig := &ConfigResource{Group: "", Version: "v1", Kind: "configmaps"}
+			rule := &ConfigRule{
+				Id:       "TestApproval",
+				Approval: &ConfigRuleApproval{Delay: testCase.delay},
+			}
+			if err := rule.Approval.Validate(); err != nil {
+				t.Fatalf("approval validation failed: %v", err)
+			}
+
+			client := j.dynClient.Resource(resourceConfig.AsGVR()).Namespace("test")
+			createdAt := time.Now().Add(-2 * time.Hour)
+
+			for _, step := range testCase.steps {
+				current, err := client.Get(ctx, "config", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("%s: unable to get resource: %v", step.name, err)
+				}
+
+				if step.before != nil {
+					step.before(current)
+					current, err = client.Update(ctx, current, metav1.UpdateOptions{})
+					if err != nil {
+						t.Fatalf("%s: unable to update resource: %v", step.name, err)
+					}
+				}
+
+				if step.restart {
+					j.state = newStateStore(NewStateBackendMemory())
+				}
+
+				ttl := "1h"
+				if step.notExpired {
+					ttl = "10h"
+				}
+
+				if err := j.checkResourceExpiryAndTriggerDelete(ctx, j.logger, resourceConfig, *current, rule, ttl, createdAt, prometheusCommon.NewMetricsList()); err != nil {
+					t.Fatalf("%s: unexpected error: %v", step.name, err)
+				}
+
+				result, err := client.Get(ctx, "config", metav1.GetOptions{})
+				if exists := !kubeErrors.IsNotFound(err); exists != step.wantExists {
+					t.Fatalf("%s: resource exists: got %v, want %v", step.name, exists, step.wantExists)
+				}
+				if result == nil {
+					continue
+				}
+
+				annotations := result.GetAnnotations()
+				if _, marked := annotations[AnnotationDefaultMarkedForDeletion]; marked != step.wantMarked {
+					t.Fatalf("%s: resource marked: got %v, want %v", step.name, marked, step.wantMarked)
+				}
+				if _, cancelled := annotations[AnnotationDefaultDeletionCancelled]; cancelled != step.wantCancelled {
+					t.Fatalf("%s: resource cancelled: got %v, want %v", step.name, cancelled, step.wantCancelled)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceApprovalScaleToZero(t *testing.T) {
+	ctx := context.Background()
+
+	resource := &unstructured.Unstructured{}
+	resource.SetAPIVersion("apps/v1")
+	resource.SetKind("Deployment")
+	resource.SetNamespace("test")
+	resource.SetName("app")
+	resource.SetUID("1a2b3c4d-5e6f")
+	if err := unstructured.SetNestedField(resource.Object, int64(2), "spec", "replicas"); err != nil {
+		t.Fatal(err)
+	}
+
+	j := newTestJanitor(t, resource)
+	auditBuffer := &bytes.Buffer{}
+	j.SetAuditLogger(NewAuditLogger(false, auditBuffer))
+	resourceConfig := &ConfigResource{Group: "apps", Version: "v1", Kind: "deployments"}
+	rule := &ConfigRule{
+		Id:       "TestApprovalScaleToZero",
+		Action:   &ConfigRuleAction{Type: ActionTypeScaleToZero},
+		Approval: &ConfigRuleApproval{Delay: "0s"},
+	}
+	if err := rule.Action.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.Approval.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := j.dynClient.Resource(resourceConfig.AsGVR()).Namespace("test")
+	createdAt := time.Now().Add(-2 * time.Hour)
+
+	run := func() *unstructured.Unstructured {
+		current, err := client.Get(ctx, "app", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get resource: %v", err)
+		}
+
+		if err := j.checkResourceExpiryAndTriggerDelete(ctx, j.logger, resourceConfig, *current, rule, "1h", createdAt, prometheusCommon.NewMetricsList()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		result, err := client.Get(ctx, "app", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get resource: %v", err)
+		}
+		return result
+	}
+
+	steps := []struct {
+		name         string
+		scaleUp      bool
+		wantMarked   bool
+		wantReplicas int64
+	}{
+		{name: "mark", wantMarked: true, wantReplicas: 2},
+		{name: "scale to zero and remove mark", wantMarked: false, wantReplicas: 0},
+		{name: "already applied, not marked again", wantMarked: false, wantReplicas: 0},
+		{name: "scaled up by owner, new approval", scaleUp: true, wantMarked: true, wantReplicas: 2},
+		{name: "scale to zero again", wantMarked: false, wantReplicas: 0},
+	}
+
+	for _, step := range steps {
+		if step.scaleUp {
+			current, err := client.Get(ctx, "app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := unstructured.SetNestedField(current.Object, int64(2), "spec", "replicas"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result := run()
+
+		if _, marked := result.GetAnnotations()[AnnotationDefaultMarkedForDeletion]; marked != step.wantMarked {
+			t.Fatalf("%s: resource marked: got %v, want %v", step.name, marked, step.wantMarked)
+		}
+
+		if replicas, _, _ := unstructured.NestedInt64(result.Object, "spec", "replicas"); replicas != step.wantReplicas {
+			t.Fatalf("%s: replicas: got %v, want %v", step.name, replicas, step.wantReplicas)
+		}
+	}
+
+	// marks are audited, already applied actions are not
+	auditActions := []string{}
+	scanner := bufio.NewScanner(auditBuffer)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("unable to parse audit record: %v", err)
+		}
+		auditActions = append(auditActions, record.Action)
+	}
+
+	wantAuditActions := []string{AuditActionMarkForDeletion, ActionTypeScaleToZero, AuditActionMarkForDeletion, ActionTypeScaleToZero}
+	if !slices.Equal(auditActions, wantAuditActions) {
+		t.Fatalf("audit actions: got %v, want %v", auditActions, wantAuditActions)
+	}
+}
@@ -0,0 +1,252 @@
+package kube_janitor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	AuditRecordType = "audit"
+
+	// audit actions of the janitor which are not rule actions
+	AuditActionMarkForDeletion = "markForDeletion"
+	AuditActionCancelDeletion  = "cancelDeletion"
+	AuditActionVolumeSnapshot  = "volumeSnapshot"
+
+	AuditStatusSuccess  = "Success"
+	AuditStatusFailure  = "Failure"
+	AuditStatusNoChange = "NoChange"
+	AuditStatusDryRun   = "DryRun"
+
+	AuditTtlSourceAnnotation = "annotation"
+	AuditTtlSourceLabel      = "label"
+	AuditTtlSourceRule       = "rule"
+)
+
+type (
+	// AuditLogger writes one JSON record per destructive action to the audit sinks (separate from the application logs),
+	// optionally every record contains the hash of the previous record (hash chain) to make the audit trail tamper-evident
+	AuditLogger struct {
+		writers   []io.Writer
+		hashChain bool
+
+		sequence int64
+		lastHash string
+
+		lock sync.Mutex
+	}
+
+	// AuditRecord is one audit record
+	AuditRecord struct {
+		Type     string    `json:"type"`
+		Time     time.Time `json:"time"`
+		Sequence int64     `json:"sequence"`
+
+		Cluster   string              `json:"cluster,omitempty"`
+		Rule      string              `json:"rule"`
+		Action    string              `json:"action"`
+		DryRun    bool                `json:"dryRun"`
+		Object    AuditRecordObject   `json:"object"`
+		TtlSource string              `json:"ttlSource,omitempty"`
+		Ttl       string              `json:"ttl,omitempty"`
+		Expiry    *time.Time          `json:"expiry,omitempty"`
+		Response  AuditRecordResponse `json:"response"`
+
+		PreviousHash string `json:"previousHash,omitempty"`
+		Hash         string `json:"hash,omitempty"`
+	}
+
+	// AuditRecordObject is the reference of the processed object
+	AuditRecordObject struct {
+		APIVersion      string `json:"apiVersion"`
+		Kind            string `json:"kind"`
+		Namespace       string `json:"namespace,omitempty"`
+		Name            string `json:"name"`
+		UID             string `json:"uid"`
+		ResourceVersion string `json:"resourceVersion"`
+	}
+
+	// AuditRecordResponse is the result of the API call
+	AuditRecordResponse struct {
+		Status  string `json:"status"`
+		Code    int32  `json:"code,omitempty"`
+		Reason  string `json:"reason,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// NewAuditLogger creates the audit logger for the writers (eg. os.Stdout or NewAuditFileWriter)
+func NewAuditLogger(hashChain bool, writers ...io.Writer) *AuditLogger {
+	return &AuditLogger{
+		writers:   writers,
+		hashChain: hashChain,
+	}
+}
+
+// NewAuditFileWriter creates a rotating file writer (maxSize in megabytes)
+func NewAuditFileWriter(path string, maxSize, maxBackups int) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+}
+
+// RestoreHashChain continues the hash chain and sequence from the last record of an existing audit file
+func (a *AuditLogger) RestoreHashChain(path string) error {
+	/* #nosec */
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close() // nolint:errcheck
+
+	var lastLine []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lastLine = bytes.Clone(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if lastLine == nil {
+		return nil
+	}
+
+	record := AuditRecord{}
+	if err := json.Unmarshal(lastLine, &record); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sequence = record.Sequence
+	a.lastHash = record.Hash
+
+	return nil
+}
+
+// write completes the record (sequence, hash chain) and writes it to all writers
+func (a *AuditLogger) write(record AuditRecord) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.sequence++
+	record.Type = AuditRecordType
+	record.Sequence = a.sequence
+
+	if a.hashChain {
+		record.PreviousHash = a.lastHash
+		record.Hash = ""
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		// hash over the previous hash and the record (without own hash)
+		hash := sha256.Sum256(append([]byte(a.lastHash), data...))
+		record.Hash = hex.EncodeToString(hash[:])
+		a.lastHash = record.Hash
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	var errs []error
+	for _, writer := range a.writers {
+		if _, err := writer.Write(data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// audit writes an audit record for an action on a resource (noop if no audit logger is set)
+func (j *Janitor) audit(ruleId, action string, resource unstructured.Unstructured, ttl string, expiry *time.Time, executed bool, err error) {
+	if j.auditLogger == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:    time.Now(),
+		Cluster: j.cluster,
+		Rule:    ruleId,
+		Action:  action,
+		DryRun:  j.dryRun,
+		Object: AuditRecordObject{
+			APIVersion:      resource.GetAPIVersion(),
+			Kind:            resource.GetKind(),
+			Namespace:       resource.GetNamespace(),
+			Name:            resource.GetName(),
+			UID:             string(resource.GetUID()),
+			ResourceVersion: resource.GetResourceVersion(),
+		},
+		Ttl:    ttl,
+		Expiry: expiry,
+	}
+
+	if ttl != "" {
+		record.TtlSource = j.auditTtlSource(ruleId, resource)
+	}
+
+	switch {
+	case j.dryRun:
+		record.Response.Status = AuditStatusDryRun
+	case err != nil:
+		record.Response.Status = AuditStatusFailure
+		record.Response.Message = err.Error()
+
+		var apiStatus apierrors.APIStatus
+		if errors.As(err, &apiStatus) {
+			record.Response.Code = apiStatus.Status().Code
+			record.Response.Reason = string(apiStatus.Status().Reason)
+		}
+	case !executed:
+		record.Response.Status = AuditStatusNoChange
+	default:
+		record.Response.Status = AuditStatusSuccess
+	}
+
+	if err := j.auditLogger.write(record); err != nil {
+		j.logger.Error("unable to write audit record", slog.Any("error", err))
+	}
+}
+
+// auditTtlSource returns where the ttl of the resource is defined (ttl annotation, ttl label or static rule)
+func (j *Janitor) auditTtlSource(ruleId string, resource unstructured.Unstructured) string {
+	if ruleId != RuleIdInternalTTL {
+		return AuditTtlSourceRule
+	}
+
+	if j.config.Ttl.Label != "" {
+		if _, exists := resource.GetLabels()[j.config.Ttl.Label]; exists {
+			return AuditTtlSourceLabel
+		}
+	}
+
+	return AuditTtlSourceAnnotation
+}
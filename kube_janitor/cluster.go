@@ -0,0 +1,175 @@
+package kube_janitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// KubeContextAll selects all contexts of the kubeconfig
+	KubeContextAll = "*"
+)
+
+type (
+	// KubeCluster is one cluster of the multi-cluster mode (kubeconfig and optional context)
+	KubeCluster struct {
+		Name       string
+		Kubeconfig string
+		Context    string
+	}
+)
+
+// DiscoverKubeClusters builds the cluster list from the contexts of the kubeconfig (* for all contexts)
+// and from all kubeconfig files inside the directory (cluster name is the file name without extension),
+// returns an empty list if neither contexts nor a directory are set (single cluster mode)
+func DiscoverKubeClusters(kubeconfig string, contexts []string, dir string) ([]KubeCluster, error) {
+	ret := []KubeCluster{}
+
+	if len(contexts) > 0 {
+		if slices.Contains(contexts, KubeContextAll) {
+			loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+			if kubeconfig != "" {
+				loadingRules.ExplicitPath = kubeconfig
+			}
+
+			rawConfig, err := loadingRules.Load()
+			if err != nil {
+				return nil, err
+			}
+
+			contexts = []string{}
+			for name := range rawConfig.Contexts {
+				contexts = append(contexts, name)
+			}
+			slices.Sort(contexts)
+		}
+
+		for _, context := range contexts {
+			ret = append(ret, KubeCluster{
+				Name:       context,
+				Kubeconfig: kubeconfig,
+				Context:    context,
+			})
+		}
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			// skip directories and hidden files (eg. ..data symlinks of mounted secrets)
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			ret = append(ret, KubeCluster{
+				Name:       strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+				Kubeconfig: filepath.Join(dir, entry.Name()),
+			})
+		}
+	}
+
+	names := map[string]bool{}
+	for _, cluster := range ret {
+		if names[cluster.Name] {
+			return nil, fmt.Errorf(`cluster "%s" is defined multiple times`, cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+
+	return ret, nil
+}
+
+// ClusterFilePath adds the cluster name to a file path (eg. reports.jsonl -> reports.dev-1.jsonl),
+// used for local files which can not be shared between clusters
+func ClusterFilePath(path, cluster string) string {
+	if cluster == "" {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + cluster + ext
+}
+
+// ForCluster returns a copy of the config with the overrides of the cluster applied
+// (excluded rules are removed, rules with the same id are replaced, other rules are added)
+func (c *Config) ForCluster(name string) *Config {
+	var override *ConfigCluster
+	for _, cluster := range c.Clusters {
+		if cluster.Name == name {
+			override = cluster
+			break
+		}
+	}
+
+	if override == nil {
+		return c
+	}
+
+	config := *c
+	excluded := func(id string) bool {
+		return slices.Contains(override.Exclude, id)
+	}
+
+	config.Rules = []*ConfigRule{}
+	for _, rule := range c.Rules {
+		if excluded(rule.Id) {
+			continue
+		}
+
+		if idx := slices.IndexFunc(override.Rules, func(r *ConfigRule) bool { return r.Id == rule.Id }); idx >= 0 {
+			rule = override.Rules[idx]
+		}
+
+		config.Rules = append(config.Rules, rule)
+	}
+
+	for _, rule := range override.Rules {
+		if !slices.ContainsFunc(c.Rules, func(r *ConfigRule) bool { return r.Id == rule.Id }) && !excluded(rule.Id) {
+			config.Rules = append(config.Rules, rule)
+		}
+	}
+
+	config.Namespaces = slices.DeleteFunc(slices.Clone(c.Namespaces), func(rule *ConfigNamespaceRule) bool { return excluded(rule.Id) })
+	config.Orphans = slices.DeleteFunc(slices.Clone(c.Orphans), func(rule *ConfigOrphanRule) bool { return excluded(rule.Id) })
+	config.Volumes = slices.DeleteFunc(slices.Clone(c.Volumes), func(rule *ConfigVolumeRule) bool { return excluded(rule.Id) })
+	config.Helm = slices.DeleteFunc(slices.Clone(c.Helm), func(rule *ConfigHelmRule) bool { return excluded(rule.Id) })
+
+	return &config
+}
+
+// UnknownClusters returns the names of the cluster overrides which don't match any of the clusters
+// (all overrides are unknown in single cluster mode)
+func (c *Config) UnknownClusters(names []string) []string {
+	ret := []string{}
+	for _, cluster := range c.Clusters {
+		if !slices.Contains(names, cluster.Name) {
+			ret = append(ret, cluster.Name)
+		}
+	}
+	return ret
+}
+
+// Validate validates the cluster overrides
+func (c *ConfigCluster) Validate() error {
+	if c.Name == "" {
+		return errors.New("cluster requires a name")
+	}
+
+	for _, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf(`cluster "%s": %w`, c.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package kube_janitor
+
+import (
+	"time"
+)
+
+const (
+	// ConditionCacheExpiry defines how long a tracked condition is remembered without being refreshed by a run
+	ConditionCacheExpiry = 7 * 24 * time.Hour
+)
+
+// conditionSince tracks since when a condition is true (across runs), returns nil if the condition is false
+func (j *Janitor) conditionSince(key string, condition bool) *time.Time {
+	if !condition {
+		j.conditionDelete(key)
+		return nil
+	}
+
+	since, exists := j.conditionGet(key)
+	if !exists {
+		since = time.Now()
+	}
+
+	// (re)set to refresh the expiry
+	j.conditionSet(key, since)
+
+	return &since
+}
+
+// conditionGet returns the tracked timestamp of a condition
+func (j *Janitor) conditionGet(key string) (time.Time, bool) {
+	return j.state.get(key)
+}
+
+// conditionSet tracks the timestamp of a condition
+func (j *Janitor) conditionSet(key string, val time.Time) {
+	j.state.set(key, val)
+}
+
+// conditionDelete removes a tracked condition
+func (j *Janitor) conditionDelete(key string) {
+	j.state.delete(key)
+}
@@ -0,0 +1,387 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+var (
+	// configEnvRegexp matches ${NAME} and ${NAME:-default}, $${ is an escaped ${
+	configEnvRegexp = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+	// configFileExtensions are the file extensions which are loaded from config directories
+	configFileExtensions = []string{".yaml", ".yml"}
+)
+
+type (
+	// configLoader loads and merges the config from multiple files (directories, globs and includes)
+	configLoader struct {
+		logger *slogger.Logger
+		config *Config
+
+		// loaded contains all loaded files, files are only loaded once (also prevents include loops)
+		loaded []string
+
+		// sources contains the file of every merged rule and resource group (for error messages)
+		sources map[string]configSource
+
+		// files contains the (expanded) content of all loaded files, used to find the line of duplicates
+		files map[string][]byte
+
+		// singletons contains the file of every top-level setting which can only be defined once
+		singletons map[string]string
+	}
+
+	configSource struct {
+		file     string
+		yamlPath string
+	}
+)
+
+// newConfigLoader creates a loader which merges all files into the config
+func newConfigLoader(logger *slogger.Logger, config *Config) *configLoader {
+	return &configLoader{
+		logger:     logger,
+		config:     config,
+		sources:    map[string]configSource{},
+		files:      map[string][]byte{},
+		singletons: map[string]string{},
+	}
+}
+
+// load loads a config file, all config files of a directory or all config files matching a glob
+func (l *configLoader) load(ctx context.Context, path string) error {
+	files, err := resolveConfigFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := l.loadFile(ctx, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFile loads one config file (with includes) and merges it into the config
+func (l *configLoader) loadFile(ctx context.Context, path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(l.loaded, absPath) {
+		l.logger.Debug("config file already loaded, skipping", slog.String("path", path))
+		return nil
+	}
+	l.loaded = append(l.loaded, absPath)
+
+	l.logger.Info("reading configuration from file", slog.String("path", path))
+
+	/* #nosec */
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf(`failed to read config file "%s": %w`, path, err)
+	}
+
+	data, err = expandConfigEnv(path, data)
+	if err != nil {
+		return err
+	}
+
+	l.files[path] = data
+
+	l.logger.Info("parsing configuration", slog.String("path", path))
+
+	// top-level keys of the file, needed to merge only the defined settings
+	keys := map[string]any{}
+	if err := yaml.UnmarshalContext(ctx, data, &keys); err != nil {
+		return fmt.Errorf(`failed to parse config file "%s": %w`, path, err)
+	}
+
+	fileConfig := NewConfig()
+	if err := yaml.UnmarshalContext(ctx, data, fileConfig, yaml.Strict(), yaml.UseJSONUnmarshaler()); err != nil {
+		return fmt.Errorf(`failed to parse config file "%s": %w`, path, err)
+	}
+
+	if err := l.merge(path, keys, fileConfig); err != nil {
+		return err
+	}
+
+	// includes are relative to the including file
+	for _, include := range fileConfig.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+
+		if err := l.load(ctx, include); err != nil {
+			return fmt.Errorf(`include "%s" of config file "%s": %w`, include, path, err)
+		}
+	}
+
+	return nil
+}
+
+// merge merges the config of one file into the config, rules and resource groups are appended
+// (duplicate ids are not allowed), all other settings can only be defined by one file
+func (l *configLoader) merge(path string, keys map[string]any, fileConfig *Config) error {
+	for _, key := range []string{"annotations", "ttl", "overlapPolicy", "defaults"} {
+		if _, exists := keys[key]; !exists {
+			continue
+		}
+
+		if previousPath, exists := l.singletons[key]; exists {
+			return fmt.Errorf(`config file "%s": %s is already defined in config file "%s"`, path, key, previousPath)
+		}
+		l.singletons[key] = path
+	}
+
+	if _, exists := keys["annotations"]; exists {
+		l.config.Annotations = fileConfig.Annotations
+	}
+	if _, exists := keys["ttl"]; exists {
+		l.config.Ttl = fileConfig.Ttl
+	}
+	if _, exists := keys["overlapPolicy"]; exists {
+		l.config.OverlapPolicy = fileConfig.OverlapPolicy
+	}
+	if _, exists := keys["defaults"]; exists {
+		l.config.Defaults = fileConfig.Defaults
+	}
+
+	// rule ids are unique per rule type
+	ruleIds := []struct {
+		key        string
+		objectType string
+		ids        []string
+	}{
+		{"rules", "rule", configRuleIds(fileConfig.Rules, func(r *ConfigRule) string { return r.Id })},
+		{"exclude", "exclude rule", configRuleIds(fileConfig.Exclude, func(r *ConfigExcludeRule) string { return r.Id })},
+		{"namespaces", "namespace rule", configRuleIds(fileConfig.Namespaces, func(r *ConfigNamespaceRule) string { return r.Id })},
+		{"orphans", "orphan rule", configRuleIds(fileConfig.Orphans, func(r *ConfigOrphanRule) string { return r.Id })},
+		{"volumes", "volume rule", configRuleIds(fileConfig.Volumes, func(r *ConfigVolumeRule) string { return r.Id })},
+		{"helm", "helm rule", configRuleIds(fileConfig.Helm, func(r *ConfigHelmRule) string { return r.Id })},
+	}
+	for _, ruleList := range ruleIds {
+		for i, id := range ruleList.ids {
+			if id == "" {
+				// reported by the validation
+				continue
+			}
+
+			source := configSource{file: path, yamlPath: fmt.Sprintf("$.%s[%d].id", ruleList.key, i)}
+			if err := l.addSource(ruleList.objectType, id, source); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name := range fileConfig.ResourceGroups {
+		source := configSource{file: path, yamlPath: fmt.Sprintf("$.resourceGroups.%s", name)}
+		if err := l.addSource("resource group", name, source); err != nil {
+			return err
+		}
+
+		if l.config.ResourceGroups == nil {
+			l.config.ResourceGroups = map[string]ConfigResourceList{}
+		}
+		l.config.ResourceGroups[name] = fileConfig.ResourceGroups[name]
+	}
+
+	for i, cluster := range fileConfig.Clusters {
+		source := configSource{file: path, yamlPath: fmt.Sprintf("$.clusters[%d].name", i)}
+		if err := l.addSource("cluster", cluster.Name, source); err != nil {
+			return err
+		}
+	}
+
+	l.config.Rules = append(l.config.Rules, fileConfig.Rules...)
+	l.config.Exclude = append(l.config.Exclude, fileConfig.Exclude...)
+	l.config.Namespaces = append(l.config.Namespaces, fileConfig.Namespaces...)
+	l.config.Orphans = append(l.config.Orphans, fileConfig.Orphans...)
+	l.config.Volumes = append(l.config.Volumes, fileConfig.Volumes...)
+	l.config.Helm = append(l.config.Helm, fileConfig.Helm...)
+	l.config.Clusters = append(l.config.Clusters, fileConfig.Clusters...)
+
+	return nil
+}
+
+// addSource registers the source of a named object, fails if the name is already defined
+func (l *configLoader) addSource(objectType, name string, source configSource) error {
+	key := objectType + "/" + name
+	if previous, exists := l.sources[key]; exists {
+		return fmt.Errorf(
+			`%s "%s" is defined multiple times: %s and %s`,
+			objectType,
+			name,
+			l.sourcePosition(previous),
+			l.sourcePosition(source),
+		)
+	}
+
+	l.sources[key] = source
+	return nil
+}
+
+// sourcePosition returns the file and line of the source (file:line)
+func (l *configLoader) sourcePosition(source configSource) string {
+	if line := configLine(l.files[source.file], source.yamlPath); line > 0 {
+		return fmt.Sprintf("%s:%d", source.file, line)
+	}
+	return source.file
+}
+
+// configRuleIds returns the ids of a rule list
+func configRuleIds[T any](rules []T, id func(T) string) []string {
+	ret := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		ret = append(ret, id(rule))
+	}
+	return ret
+}
+
+// configLine returns the line of the yaml path (eg. $.rules[0].id) inside the config file, 0 if not found
+func configLine(data []byte, path string) int {
+	yamlPath, err := yaml.PathString(path)
+	if err != nil {
+		return 0
+	}
+
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return 0
+	}
+
+	node, err := yamlPath.FilterFile(file)
+	if err != nil || node == nil || node.GetToken() == nil {
+		return 0
+	}
+
+	return node.GetToken().Position.Line
+}
+
+// resolveConfigFiles returns the config files of the path (file, directory or glob)
+func resolveConfigFiles(path string) ([]string, error) {
+	// glob
+	if strings.ContainsAny(path, "*?[") {
+		files, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid config glob "%s": %w`, path, err)
+		}
+
+		if len(files) == 0 {
+			return nil, fmt.Errorf(`no config files found for "%s"`, path)
+		}
+
+		return files, nil
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read config "%s": %w`, path, err)
+	}
+
+	// single file
+	if !stat.IsDir() {
+		return []string{path}, nil
+	}
+
+	// directory, all config files sorted by name
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to read config directory "%s": %w`, path, err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		// skip directories and hidden files (eg. ..data symlinks of mounted ConfigMaps)
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if !slices.Contains(configFileExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf(`no config files (%s) found in directory "%s"`, strings.Join(configFileExtensions, ", "), path)
+	}
+
+	return files, nil
+}
+
+// expandConfigEnv replaces ${NAME} and ${NAME:-default} with the environment variables,
+// fails if a variable without default is not set. Comments are not expanded (eg. commented out settings)
+func expandConfigEnv(path string, data []byte) ([]byte, error) {
+	var err error
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		content, comment := splitConfigComment(line)
+		lines[i] = configEnvRegexp.ReplaceAllStringFunc(content, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+
+			parts := configEnvRegexp.FindStringSubmatch(match)
+			if val, exists := os.LookupEnv(parts[1]); exists {
+				return val
+			}
+
+			if parts[2] != "" {
+				return parts[3]
+			}
+
+			if err == nil {
+				err = fmt.Errorf(`%s:%d: environment variable "%s" is not set`, path, i+1, parts[1])
+			}
+			return match
+		}) + comment
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// splitConfigComment splits a yaml line into content and comment,
+// comments start with # at the line start or after a whitespace (outside of quoted strings)
+func splitConfigComment(line string) (string, string) {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		switch {
+		case quote == '"' && char == '\\':
+			// escaped char inside double quotes
+			i++
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], line[i:]
+		case (char == '"' || char == '\'') && (i == 0 || strings.IndexByte(" \t[{,", line[i-1]) >= 0):
+			// quoted strings start at the beginning of a value
+			quote = char
+		}
+	}
+
+	return line, ""
+}
@@ -0,0 +1,189 @@
+package kube_janitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+// testConfigFiles writes the config files into a temporary directory and returns the directory
+func testConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestConfigLoader(t *testing.T) {
+	files := map[string]string{
+		"conf.d/10-base.yaml": "include:\n  - ../teams/*.yaml\nrules:\n  - id: CleanupBase\n    ttl: 1d\n",
+		"conf.d/20-extra.yml": "rules:\n  - id: CleanupExtra\n    ttl: 2d\n",
+		"conf.d/.hidden.yaml": "invalid: [",
+		"conf.d/README.md":    "not a config file",
+		"teams/a.yaml":        "include:\n  - ../conf.d/10-base.yaml\nrules:\n  - id: CleanupTeamA\n    ttl: 3d\n",
+		"teams/b.yaml":        "rules:\n  - id: CleanupTeamB\n    ttl: 4d\n",
+		"duplicate/a.yaml":    "rules:\n  - id: CleanupA\n    ttl: 1d\n  - id: CleanupDuplicate\n    ttl: 1d\n",
+		"duplicate/b.yaml":    "# duplicate of a.yaml\nrules:\n  - id: CleanupDuplicate\n    ttl: 2d\n",
+		"singleton/a.yaml":    "overlapPolicy: firstMatch\n",
+		"singleton/b.yaml":    "overlapPolicy: shortestTtl\n",
+	}
+	dir := testConfigFiles(t, files)
+
+	testCases := []struct {
+		name        string
+		path        string
+		wantRuleIds []string
+		wantErr     []string
+	}{
+		{
+			name:        "directory with includes (loaded once)",
+			path:        "conf.d",
+			wantRuleIds: []string{"CleanupBase", "CleanupTeamA", "CleanupTeamB", "CleanupExtra"},
+		},
+		{
+			name:        "glob",
+			path:        "teams/*.yaml",
+			wantRuleIds: []string{"CleanupTeamA", "CleanupBase", "CleanupTeamB"},
+		},
+		{
+			name:        "single file",
+			path:        "conf.d/20-extra.yml",
+			wantRuleIds: []string{"CleanupExtra"},
+		},
+		{
+			name:    "duplicate id",
+			path:    "duplicate",
+			wantErr: []string{`rule "CleanupDuplicate" is defined multiple times`, "a.yaml:4", "b.yaml:3"},
+		},
+		{
+			name:    "singleton defined twice",
+			path:    "singleton",
+			wantErr: []string{"overlapPolicy is already defined"},
+		},
+		{
+			name:    "glob without files",
+			path:    "missing/*.yaml",
+			wantErr: []string{"no config files found"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := NewConfig()
+			err := newConfigLoader(slogger.NewDiscardLogger(), config).load(context.Background(), filepath.Join(dir, testCase.path))
+
+			if len(testCase.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("expected error, got rules %v", configRuleIds(config.Rules, func(r *ConfigRule) string { return r.Id }))
+				}
+				for _, wantErr := range testCase.wantErr {
+					if !strings.Contains(err.Error(), wantErr) {
+						t.Fatalf("error %q doesn't contain %q", err.Error(), wantErr)
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ruleIds := configRuleIds(config.Rules, func(r *ConfigRule) string { return r.Id }); !slices.Equal(ruleIds, testCase.wantRuleIds) {
+				t.Fatalf("rules: got %v, want %v", ruleIds, testCase.wantRuleIds)
+			}
+		})
+	}
+}
+
+func TestExpandConfigEnv(t *testing.T) {
+	t.Setenv("JANITOR_TEST_ENV", "prod")
+
+	testCases := []struct {
+		name     string
+		data     string
+		wantData string
+		wantErr  string
+	}{
+		{
+			name:     "variable",
+			data:     "ttl: ${JANITOR_TEST_ENV}",
+			wantData: "ttl: prod",
+		},
+		{
+			name:     "default value",
+			data:     "ttl: ${JANITOR_TEST_UNSET:-1d}",
+			wantData: "ttl: 1d",
+		},
+		{
+			name:     "default value of set variable",
+			data:     "ttl: ${JANITOR_TEST_ENV:-1d}",
+			wantData: "ttl: prod",
+		},
+		{
+			name:     "escaped",
+			data:     "filterPath: $${JANITOR_TEST_ENV}",
+			wantData: "filterPath: ${JANITOR_TEST_ENV}",
+		},
+		{
+			name:    "unset variable",
+			data:    "id: Cleanup\nttl: ${JANITOR_TEST_UNSET}",
+			wantErr: `config.yaml:2: environment variable "JANITOR_TEST_UNSET"`,
+		},
+		{
+			name:     "commented line",
+			data:     "# ttl: ${JANITOR_TEST_UNSET}\n  ## ${JANITOR_TEST_UNSET}",
+			wantData: "# ttl: ${JANITOR_TEST_UNSET}\n  ## ${JANITOR_TEST_UNSET}",
+		},
+		{
+			name:     "trailing comment",
+			data:     "ttl: ${JANITOR_TEST_ENV} # ${JANITOR_TEST_UNSET}",
+			wantData: "ttl: prod # ${JANITOR_TEST_UNSET}",
+		},
+		{
+			name:     "hash inside quotes",
+			data:     `name: "pr #${JANITOR_TEST_ENV}" # ${JANITOR_TEST_UNSET}`,
+			wantData: `name: "pr #prod" # ${JANITOR_TEST_UNSET}`,
+		},
+		{
+			name:     "hash inside value",
+			data:     "selector: app#${JANITOR_TEST_ENV}",
+			wantData: "selector: app#prod",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := expandConfigEnv("config.yaml", []byte(testCase.data))
+
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Fatalf("error: got %v, want %q", err, testCase.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if string(data) != testCase.wantData {
+				t.Fatalf("data: got %q, want %q", string(data), testCase.wantData)
+			}
+		})
+	}
+}
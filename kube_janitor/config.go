@@ -1,20 +1,69 @@
 package kube_janitor
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
+	"net/url"
+	"path"
+	"slices"
 	"strings"
+	"time"
 
+	"fortio.org/duration"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+const (
+	AnnotationDefaultKeep              = "janitor/keep"
+	AnnotationDefaultMarkedForDeletion = "janitor/marked-for-deletion"
+	AnnotationDefaultDeletionCancelled = "janitor/deletion-cancelled"
+	AnnotationDefaultTtlExtend         = "janitor/ttl-extend"
+	AnnotationDefaultSnoozeUntil       = "janitor/snooze-until"
+	AnnotationDefaultLastUsed          = "janitor/last-used"
+)
+
 type (
 	Config struct {
-		Ttl   *ConfigTtl    `json:"ttl"`
-		Rules []*ConfigRule `json:"rules"`
+		Annotations ConfigAnnotations `json:"annotations"`
+
+		Ttl        *ConfigTtl             `json:"ttl"`
+		Rules      []*ConfigRule          `json:"rules"`
+		Exclude    []*ConfigExcludeRule   `json:"exclude"`
+		Namespaces []*ConfigNamespaceRule `json:"namespaces"`
+		Orphans    []*ConfigOrphanRule    `json:"orphans"`
+		Volumes    []*ConfigVolumeRule    `json:"volumes"`
+		Helm       []*ConfigHelmRule      `json:"helm"`
+
+		// OverlapPolicy decides which rule processes a resource matched by multiple rules
+		OverlapPolicy string `json:"overlapPolicy"`
+
+		// Defaults are inherited by all rules which don't set the setting themselves
+		Defaults *ConfigRuleDefaults `json:"defaults"`
+
+		// ResourceGroups are named resource lists which can be referenced by rules (resourceGroups)
+		ResourceGroups map[string]ConfigResourceList `json:"resourceGroups"`
+
+		Clusters []*ConfigCluster `json:"clusters"`
+
+		// Include loads additional config files, directories or globs (relative to the including file)
+		Include []string `json:"include"`
+	}
+
+	// ConfigCluster contains the rule overrides for one cluster (multi-cluster mode)
+	ConfigCluster struct {
+		Name    string        `json:"name"`
+		Exclude []string      `json:"exclude"`
+		Rules   []*ConfigRule `json:"rules"`
+	}
+
+	ConfigAnnotations struct {
+		Keep              string `json:"keep"`
+		MarkedForDeletion string `json:"markedForDeletion"`
+		DeletionCancelled string `json:"deletionCancelled"`
+		TtlExtend         string `json:"ttlExtend"`
+		SnoozeUntil       string `json:"snoozeUntil"`
+		LastUsed          string `json:"lastUsed"`
 	}
 
 	ConfigTtl struct {
@@ -22,25 +71,185 @@ type (
 		Label      string             `json:"label"`
 		Resources  ConfigResourceList `json:"resources"`
 
+		MaxLifetime  string              `json:"maxLifetime"`
+		Approval     *ConfigRuleApproval `json:"approval"`
+		Notification *ConfigNotification `json:"notification"`
+		Owners       ConfigRuleOwners    `json:"owners"`
+		Finalizers   *ConfigFinalizers   `json:"finalizers"`
+
 		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
+
+		maxLifetime time.Duration
 	}
 
 	ConfigResourceList []*ConfigResource
 
 	ConfigResource struct {
-		Group         string              `json:"group"`
-		Version       string              `json:"version"`
-		Kind          string              `json:"kind"`
-		Selector      ConfigLabelSelector `json:"selector"`
-		TimestampPath *JmesPath           `json:"timestampPath"`
-		FilterPath    *JmesPath           `json:"filterPath"`
+		Group           string              `json:"group"`
+		Version         string              `json:"version"`
+		Kind            string              `json:"kind"`
+		Selector        ConfigLabelSelector `json:"selector"`
+		FieldSelector   string              `json:"fieldSelector"`
+		Names           ConfigPatternList   `json:"names"`
+		ExcludeNames    ConfigPatternList   `json:"excludeNames"`
+		TimestampSource string              `json:"timestampSource"`
+		TimestampPath   *JmesPath           `json:"timestampPath"`
+		FilterPath      *JmesPath           `json:"filterPath"`
 	}
 
 	ConfigRule struct {
 		Id                string              `json:"id"`
+		Priority          int                 `json:"priority"`
+		ResourceGroups    []string            `json:"resourceGroups"`
+		IgnoreDefaults    bool                `json:"ignoreDefaults"`
 		Resources         ConfigResourceList  `json:"resources"`
 		NamespaceSelector ConfigLabelSelector `json:"namespaceSelector"`
+		Namespaces        ConfigPatternList   `json:"namespaces"`
+		ExcludeNamespaces ConfigPatternList   `json:"excludeNamespaces"`
 		Ttl               string              `json:"ttl"`
+		ConditionFor      string              `json:"conditionFor"`
+		MaxLifetime       string              `json:"maxLifetime"`
+		Action            *ConfigRuleAction   `json:"action"`
+		Approval          *ConfigRuleApproval `json:"approval"`
+		Notification      *ConfigNotification `json:"notification"`
+		Owners            ConfigRuleOwners    `json:"owners"`
+		Finalizers        *ConfigFinalizers   `json:"finalizers"`
+
+		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
+
+		conditionFor time.Duration
+		maxLifetime  time.Duration
+
+		defaultsApplied bool
+	}
+
+	// ConfigRuleDefaults contains the settings inherited by all rules (see Config.applyRuleDefaults)
+	ConfigRuleDefaults struct {
+		NamespaceSelector ConfigLabelSelector     `json:"namespaceSelector"`
+		DeleteOptions     ConfigRuleDeleteOptions `json:"deleteOptions"`
+		Action            *ConfigRuleAction       `json:"action"`
+		Notification      *ConfigNotification     `json:"notification"`
+	}
+
+	// ConfigExcludeRule excludes the matched resources from all rules (or the listed rules)
+	ConfigExcludeRule struct {
+		Id                string              `json:"id"`
+		Rules             []string            `json:"rules"`
+		Resources         ConfigResourceList  `json:"resources"`
+		NamespaceSelector ConfigLabelSelector `json:"namespaceSelector"`
+		Namespaces        ConfigPatternList   `json:"namespaces"`
+		ExcludeNamespaces ConfigPatternList   `json:"excludeNamespaces"`
+	}
+
+	ConfigRuleApproval struct {
+		Delay string `json:"delay"`
+
+		delay time.Duration
+	}
+
+	ConfigRuleOwners struct {
+		Mode  string `json:"mode"`
+		Order string `json:"order"`
+	}
+
+	ConfigFinalizers struct {
+		StuckAfter string                  `json:"stuckAfter"`
+		Remove     *ConfigFinalizersRemove `json:"remove"`
+
+		stuckAfter time.Duration
+	}
+
+	ConfigFinalizersRemove struct {
+		After string   `json:"after"`
+		Allow []string `json:"allow"`
+
+		after time.Duration
+	}
+
+	ConfigNotification struct {
+		Webhook string            `json:"webhook"`
+		Headers map[string]string `json:"headers"`
+	}
+
+	ConfigRuleAction struct {
+		Type        string            `json:"type"`
+		PatchType   string            `json:"patchType"`
+		Patch       string            `json:"patch"`
+		Annotations map[string]string `json:"annotations"`
+		Labels      map[string]string `json:"labels"`
+
+		action JanitorAction
+	}
+
+	ConfigNamespaceRule struct {
+		Id                string                  `json:"id"`
+		NamespaceSelector ConfigLabelSelector     `json:"namespaceSelector"`
+		Ttl               string                  `json:"ttl"`
+		Teardown          ConfigNamespaceTeardown `json:"teardown"`
+		Empty             *ConfigNamespaceEmpty   `json:"empty"`
+
+		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
+	}
+
+	ConfigNamespaceEmpty struct {
+		Ignore []*ConfigNamespaceEmptyIgnore `json:"ignore"`
+	}
+
+	ConfigNamespaceEmptyIgnore struct {
+		Group   string   `json:"group"`
+		Version string   `json:"version"`
+		Kind    string   `json:"kind"`
+		Names   []string `json:"names"`
+	}
+
+	ConfigNamespaceTeardown struct {
+		Order          []*ConfigNamespaceTeardownStep `json:"order"`
+		StuckAfter     string                         `json:"stuckAfter"`
+		ListFinalizers bool                           `json:"listFinalizers"`
+
+		stuckAfter time.Duration
+	}
+
+	ConfigNamespaceTeardownStep struct {
+		Name      string             `json:"name"`
+		Resources ConfigResourceList `json:"resources"`
+	}
+
+	ConfigOrphanRule struct {
+		Id                string              `json:"id"`
+		Resources         ConfigResourceList  `json:"resources"`
+		NamespaceSelector ConfigLabelSelector `json:"namespaceSelector"`
+		Ttl               string              `json:"ttl"`
+		Allow             []string            `json:"allow"`
+
+		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
+	}
+
+	ConfigVolumeRule struct {
+		Id                string                `json:"id"`
+		Type              string                `json:"type"`
+		Ttl               string                `json:"ttl"`
+		Selector          ConfigLabelSelector   `json:"selector"`
+		NamespaceSelector ConfigLabelSelector   `json:"namespaceSelector"`
+		Snapshot          *ConfigVolumeSnapshot `json:"snapshot"`
+
+		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
+	}
+
+	ConfigVolumeSnapshot struct {
+		VolumeSnapshotClassName string `json:"volumeSnapshotClassName"`
+		Timeout                 string `json:"timeout"`
+
+		timeout time.Duration
+	}
+
+	ConfigHelmRule struct {
+		Id                string              `json:"id"`
+		NamespaceSelector ConfigLabelSelector `json:"namespaceSelector"`
+		Selector          ConfigLabelSelector `json:"selector"`
+		Ttl               string              `json:"ttl"`
+		TtlLabel          string              `json:"ttlLabel"`
+		TtlValuesPath     *JmesPath           `json:"ttlValuesPath"`
 
 		DeleteOptions ConfigRuleDeleteOptions `json:"deleteOptions"`
 	}
@@ -64,22 +273,189 @@ func NewConfig() *Config {
 		Ttl: &ConfigTtl{
 			Resources: []*ConfigResource{},
 		},
-		Rules: []*ConfigRule{},
+		Rules:      []*ConfigRule{},
+		Namespaces: []*ConfigNamespaceRule{},
+		Orphans:    []*ConfigOrphanRule{},
+		Volumes:    []*ConfigVolumeRule{},
 	}
 }
 
 // Validate validates the config and all sub objects
 func (c *Config) Validate() error {
+	if err := c.Annotations.Validate(); err != nil {
+		return err
+	}
+
 	if err := c.Ttl.Validate(); err != nil {
 		return err
 	}
 
+	if err := c.validateResourceGroups(); err != nil {
+		return err
+	}
+
+	if c.Defaults != nil {
+		if err := c.Defaults.Validate(); err != nil {
+			return err
+		}
+	}
+
 	for _, rule := range c.Rules {
+		if err := c.applyRuleDefaults(rule); err != nil {
+			return err
+		}
+
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range c.Exclude {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch c.OverlapPolicy {
+	case "", OverlapPolicyAll, OverlapPolicyFirstMatch, OverlapPolicyShortestTtl, OverlapPolicyLongestTtl:
+	default:
+		return fmt.Errorf(
+			`overlapPolicy must be %s, %s, %s or %s`,
+			OverlapPolicyAll,
+			OverlapPolicyFirstMatch,
+			OverlapPolicyShortestTtl,
+			OverlapPolicyLongestTtl,
+		)
+	}
+
+	for _, rule := range c.Namespaces {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range c.Orphans {
 		if err := rule.Validate(); err != nil {
 			return err
 		}
 	}
 
+	for _, rule := range c.Volumes {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range c.Helm {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, cluster := range c.Clusters {
+		for _, rule := range cluster.Rules {
+			if err := c.applyRuleDefaults(rule); err != nil {
+				return fmt.Errorf(`cluster "%s": %w`, cluster.Name, err)
+			}
+		}
+
+		if err := cluster.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateExcludeRuleIds(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateExcludeRuleIds checks that the exclude rules only reference existing rules
+// (static, namespace, orphan, volume, helm and cluster rules or the ttl annotation run)
+func (c *Config) validateExcludeRuleIds() error {
+	ruleIds := []string{RuleIdInternalTTL}
+	for _, rule := range c.Rules {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	for _, cluster := range c.Clusters {
+		for _, rule := range cluster.Rules {
+			ruleIds = append(ruleIds, rule.Id)
+		}
+	}
+	for _, rule := range c.Namespaces {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	for _, rule := range c.Orphans {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	for _, rule := range c.Volumes {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+	for _, rule := range c.Helm {
+		ruleIds = append(ruleIds, rule.Id)
+	}
+
+	for _, excludeRule := range c.Exclude {
+		for _, ruleId := range excludeRule.Rules {
+			if !slices.Contains(ruleIds, ruleId) {
+				return fmt.Errorf(`exclude rule "%s": rule "%s" doesn't exist`, excludeRule.Id, ruleId)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the exclude rule
+func (c *ConfigExcludeRule) Validate() error {
+	if c.Id == "" {
+		return errors.New("exclude rules requires an id")
+	}
+
+	if len(c.Resources) == 0 {
+		return fmt.Errorf(`exclude rule "%s": requires at least one resource`, c.Id)
+	}
+
+	if err := c.Resources.Validate(); err != nil {
+		return fmt.Errorf(`exclude rule "%s": %w`, c.Id, err)
+	}
+
+	return nil
+}
+
+// Validate validates the annotation names and sets the defaults
+func (c *ConfigAnnotations) Validate() error {
+	if c.Keep == "" {
+		c.Keep = AnnotationDefaultKeep
+	}
+
+	if c.MarkedForDeletion == "" {
+		c.MarkedForDeletion = AnnotationDefaultMarkedForDeletion
+	}
+
+	if c.DeletionCancelled == "" {
+		c.DeletionCancelled = AnnotationDefaultDeletionCancelled
+	}
+
+	if c.TtlExtend == "" {
+		c.TtlExtend = AnnotationDefaultTtlExtend
+	}
+
+	if c.SnoozeUntil == "" {
+		c.SnoozeUntil = AnnotationDefaultSnoozeUntil
+	}
+
+	if c.LastUsed == "" {
+		c.LastUsed = AnnotationDefaultLastUsed
+	}
+
+	for _, annotation := range []string{c.Keep, c.MarkedForDeletion, c.DeletionCancelled, c.TtlExtend, c.SnoozeUntil, c.LastUsed} {
+		if strings.Contains(annotation, " ") {
+			return fmt.Errorf(`annotation "%s" must not contain spaces`, annotation)
+		}
+	}
+
 	return nil
 }
 
@@ -91,6 +467,40 @@ func (c *ConfigTtl) Validate() error {
 		}
 	}
 
+	if err := c.Resources.Validate(); err != nil {
+		return err
+	}
+
+	if c.MaxLifetime != "" {
+		val, err := duration.Parse(c.MaxLifetime)
+		if err != nil {
+			return fmt.Errorf(`unable to parse maxLifetime "%s": %w`, c.MaxLifetime, err)
+		}
+		c.maxLifetime = val
+	}
+
+	if c.Approval != nil {
+		if err := c.Approval.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Notification != nil {
+		if err := c.Notification.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Owners.Validate(); err != nil {
+		return err
+	}
+
+	if c.Finalizers != nil {
+		if err := c.Finalizers.Validate(); err != nil {
+			return err
+		}
+	}
+
 	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
 		return err
 	}
@@ -108,6 +518,54 @@ func (c *ConfigRule) Validate() error {
 		return errors.New("rules requires at least one resource")
 	}
 
+	if err := c.Resources.Validate(); err != nil {
+		return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+	}
+
+	if c.Action != nil {
+		if err := c.Action.Validate(); err != nil {
+			return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+		}
+	}
+
+	if c.ConditionFor != "" {
+		val, err := duration.Parse(c.ConditionFor)
+		if err != nil {
+			return fmt.Errorf(`rule "%s": unable to parse conditionFor "%s": %w`, c.Id, c.ConditionFor, err)
+		}
+		c.conditionFor = val
+	}
+
+	if c.MaxLifetime != "" {
+		val, err := duration.Parse(c.MaxLifetime)
+		if err != nil {
+			return fmt.Errorf(`rule "%s": unable to parse maxLifetime "%s": %w`, c.Id, c.MaxLifetime, err)
+		}
+		c.maxLifetime = val
+	}
+
+	if c.Approval != nil {
+		if err := c.Approval.Validate(); err != nil {
+			return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+		}
+	}
+
+	if c.Notification != nil {
+		if err := c.Notification.Validate(); err != nil {
+			return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+		}
+	}
+
+	if err := c.Owners.Validate(); err != nil {
+		return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+	}
+
+	if c.Finalizers != nil {
+		if err := c.Finalizers.Validate(); err != nil {
+			return fmt.Errorf(`rule "%s": %w`, c.Id, err)
+		}
+	}
+
 	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
 		return err
 	}
@@ -115,18 +573,407 @@ func (c *ConfigRule) Validate() error {
 	return nil
 }
 
-// Clone clones the object
-func (c *ConfigResource) Clone() *ConfigResource {
-	ret := ConfigResource{}
-	buf := bytes.Buffer{}
-	err := gob.NewEncoder(&buf).Encode(c)
+// GetAction returns the action of the rule (delete if no action is configured)
+func (c *ConfigRule) GetAction() JanitorAction {
+	if c.Action == nil || c.Action.action == nil {
+		return &janitorActionDelete{}
+	}
+
+	return c.Action.action
+}
+
+// isNamespaced returns true if the rule is limited to namespaces (cluster resources are not matched)
+func (c *ConfigRule) isNamespaced() bool {
+	return !c.NamespaceSelector.IsEmpty() || !c.Namespaces.IsEmpty() || !c.ExcludeNamespaces.IsEmpty()
+}
+
+// Validate validates the rule action and builds the action implementation
+func (c *ConfigRuleAction) Validate() error {
+	action, err := newJanitorAction(c)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = gob.NewDecoder(&buf).Decode(&ret)
+
+	c.action = action
+	return nil
+}
+
+// Validate validates the approval settings and parses the delay
+func (c *ConfigRuleApproval) Validate() error {
+	if c.Delay == "" {
+		return errors.New("approval requires a delay")
+	}
+
+	val, err := duration.Parse(c.Delay)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf(`unable to parse approval delay "%s": %w`, c.Delay, err)
 	}
+	c.delay = val
+
+	return nil
+}
+
+// Validate validates the owner handling settings
+func (c *ConfigRuleOwners) Validate() error {
+	switch c.Mode {
+	case "", OwnerModeSkip, OwnerModeOwner, OwnerModeIgnore:
+	default:
+		return fmt.Errorf(`owners mode must be %s, %s or %s`, OwnerModeSkip, OwnerModeOwner, OwnerModeIgnore)
+	}
+
+	switch c.Order {
+	case "", OwnerOrderOwnersFirst, OwnerOrderDependentsFirst:
+	default:
+		return fmt.Errorf(`owners order must be %s or %s`, OwnerOrderOwnersFirst, OwnerOrderDependentsFirst)
+	}
+
+	return nil
+}
+
+// Validate validates the finalizer settings and parses the durations
+func (c *ConfigFinalizers) Validate() error {
+	c.stuckAfter = FinalizersDefaultStuckAfter
+	if c.StuckAfter != "" {
+		val, err := duration.Parse(c.StuckAfter)
+		if err != nil {
+			return fmt.Errorf(`unable to parse finalizers stuckAfter "%s": %w`, c.StuckAfter, err)
+		}
+		c.stuckAfter = val
+	}
+
+	if c.Remove != nil {
+		if len(c.Remove.Allow) == 0 {
+			return errors.New("finalizers remove requires an allow list of finalizer names")
+		}
+
+		for _, finalizer := range c.Remove.Allow {
+			if _, err := path.Match(finalizer, ""); err != nil {
+				return fmt.Errorf(`invalid finalizer pattern "%s": %w`, finalizer, err)
+			}
+		}
+
+		if c.Remove.After == "" {
+			return errors.New("finalizers remove requires an after duration")
+		}
+
+		val, err := duration.Parse(c.Remove.After)
+		if err != nil {
+			return fmt.Errorf(`unable to parse finalizers remove after "%s": %w`, c.Remove.After, err)
+		}
+		c.Remove.after = val
+	}
+
+	return nil
+}
+
+// Validate validates the notification settings
+func (c *ConfigNotification) Validate() error {
+	if c.Webhook == "" {
+		return errors.New("notification requires a webhook")
+	}
+
+	if _, err := url.ParseRequestURI(c.Webhook); err != nil {
+		return fmt.Errorf(`invalid notification webhook: %w`, err)
+	}
+
+	return nil
+}
+
+// Validate validates the namespace rule
+func (c *ConfigNamespaceRule) Validate() error {
+	if c.Id == "" {
+		return errors.New("namespace rules requires an id")
+	}
+
+	if c.Ttl == "" {
+		return fmt.Errorf(`namespace rule "%s" requires a ttl`, c.Id)
+	}
+
+	if err := c.Teardown.Validate(); err != nil {
+		return fmt.Errorf(`namespace rule "%s": %w`, c.Id, err)
+	}
+
+	if c.Empty != nil {
+		if err := c.Empty.Validate(); err != nil {
+			return fmt.Errorf(`namespace rule "%s": %w`, c.Id, err)
+		}
+	}
+
+	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates the namespace teardown settings and parses the stuck duration
+func (c *ConfigNamespaceTeardown) Validate() error {
+	for _, step := range c.Order {
+		if len(step.Resources) == 0 {
+			return fmt.Errorf(`teardown step "%s" requires at least one resource`, step.Name)
+		}
+	}
+
+	c.stuckAfter = NamespaceTeardownDefaultStuckAfter
+	if c.StuckAfter != "" {
+		val, err := duration.Parse(c.StuckAfter)
+		if err != nil {
+			return fmt.Errorf(`unable to parse stuckAfter "%s": %w`, c.StuckAfter, err)
+		}
+		c.stuckAfter = val
+	}
+
+	return nil
+}
+
+// Validate validates the empty namespace settings
+func (c *ConfigNamespaceEmpty) Validate() error {
+	for _, ignore := range c.Ignore {
+		if ignore.Kind == "" {
+			return errors.New("empty namespace ignore entries require a kind")
+		}
+
+		for _, name := range ignore.Names {
+			if _, err := path.Match(name, ""); err != nil {
+				return fmt.Errorf(`invalid name pattern "%s" in empty namespace ignore list: %w`, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// IgnoreList returns the configured ignore list or the default ignore list if none is configured
+func (c *ConfigNamespaceEmpty) IgnoreList() []*ConfigNamespaceEmptyIgnore {
+	if len(c.Ignore) > 0 {
+		return c.Ignore
+	}
+
+	return []*ConfigNamespaceEmptyIgnore{
+		{Group: "", Kind: "serviceaccounts", Names: []string{"default"}},
+		{Group: "", Kind: "configmaps", Names: []string{"kube-root-ca.crt", "openshift-service-ca.crt"}},
+		{Group: "", Kind: "secrets", Names: []string{"default-token-*", "default-dockercfg-*"}},
+		{Group: "", Kind: "events"},
+		{Group: "events.k8s.io", Kind: "events"},
+	}
+}
+
+// Matches checks if the resource (by server GVK and name) is ignored
+func (c *ConfigNamespaceEmptyIgnore) Matches(gvk KubeServerGroupVersionKind, name string) bool {
+	if !strings.EqualFold(c.Group, gvk.Group) {
+		return false
+	}
+
+	if c.Version != "" && c.Version != "*" && !strings.EqualFold(c.Version, gvk.Version) {
+		return false
+	}
+
+	if c.Kind != "*" && !strings.EqualFold(c.Kind, gvk.Kind) {
+		return false
+	}
+
+	// no names, ignore all resources of this kind
+	if len(c.Names) == 0 {
+		return true
+	}
+
+	for _, pattern := range c.Names {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Steps returns the configured teardown order or the default order if none is configured
+func (c *ConfigNamespaceTeardown) Steps() []*ConfigNamespaceTeardownStep {
+	if len(c.Order) > 0 {
+		return c.Order
+	}
+
+	return []*ConfigNamespaceTeardownStep{
+		{
+			Name: "workloads",
+			Resources: ConfigResourceList{
+				{Group: "batch", Version: "v1", Kind: "cronjobs"},
+				{Group: "apps", Version: "v1", Kind: "deployments"},
+				{Group: "apps", Version: "v1", Kind: "statefulsets"},
+				{Group: "apps", Version: "v1", Kind: "daemonsets"},
+				{Group: "batch", Version: "v1", Kind: "jobs"},
+				{Group: "apps", Version: "v1", Kind: "replicasets"},
+				{Group: "", Version: "v1", Kind: "pods"},
+			},
+		},
+		{
+			Name: "volumes",
+			Resources: ConfigResourceList{
+				{Group: "", Version: "v1", Kind: "persistentvolumeclaims"},
+			},
+		},
+	}
+}
+
+// Validate validates the orphan rule
+func (c *ConfigOrphanRule) Validate() error {
+	if c.Id == "" {
+		return errors.New("orphan rules requires an id")
+	}
+
+	if c.Ttl == "" {
+		return fmt.Errorf(`orphan rule "%s" requires a ttl`, c.Id)
+	}
+
+	for _, resource := range c.Resources {
+		if resource.Group != "" || (resource.Kind != "configmaps" && resource.Kind != "secrets") {
+			return fmt.Errorf(`orphan rule "%s" only supports configmaps and secrets, got "%s"`, c.Id, resource.String())
+		}
+
+		if resource.Version == "" {
+			resource.Version = "v1"
+		}
+	}
+
+	for _, name := range c.Allow {
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf(`orphan rule "%s": invalid allow pattern "%s": %w`, c.Id, name, err)
+		}
+	}
+
+	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates the helm rule and restricts the selector to helm release secrets
+func (c *ConfigHelmRule) Validate() error {
+	if c.Id == "" {
+		return errors.New("helm rules requires an id")
+	}
+
+	if c.Ttl == "" && c.TtlLabel == "" && c.TtlValuesPath.IsEmpty() {
+		return fmt.Errorf(`helm rule "%s" requires a ttl, ttlLabel or ttlValuesPath`, c.Id)
+	}
+
+	if c.Selector.MatchLabels == nil {
+		c.Selector.MatchLabels = map[string]string{}
+	}
+	c.Selector.MatchLabels[HelmReleaseLabelOwner] = HelmReleaseOwner
+
+	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResourceList returns the configured resources or configmaps and secrets if none are configured
+func (c *ConfigOrphanRule) ResourceList() ConfigResourceList {
+	if len(c.Resources) > 0 {
+		return c.Resources
+	}
+
+	return ConfigResourceList{
+		{Group: "", Version: "v1", Kind: "configmaps"},
+		{Group: "", Version: "v1", Kind: "secrets"},
+	}
+}
+
+// Validate validates the volume rule
+func (c *ConfigVolumeRule) Validate() error {
+	if c.Id == "" {
+		return errors.New("volume rules requires an id")
+	}
+
+	if c.Ttl == "" {
+		return fmt.Errorf(`volume rule "%s" requires a ttl`, c.Id)
+	}
+
+	switch c.Type {
+	case VolumeRuleTypeUnusedClaim:
+		if c.Snapshot != nil {
+			if err := c.Snapshot.Validate(); err != nil {
+				return fmt.Errorf(`volume rule "%s": %w`, c.Id, err)
+			}
+		}
+	case VolumeRuleTypeReleasedVolume:
+		if c.Snapshot != nil {
+			return fmt.Errorf(`volume rule "%s": snapshot is only supported for type %s`, c.Id, VolumeRuleTypeUnusedClaim)
+		}
+
+		if !c.NamespaceSelector.IsEmpty() {
+			return fmt.Errorf(`volume rule "%s": namespaceSelector is not supported for type %s`, c.Id, VolumeRuleTypeReleasedVolume)
+		}
+	default:
+		return fmt.Errorf(`volume rule "%s": type must be %s or %s`, c.Id, VolumeRuleTypeUnusedClaim, VolumeRuleTypeReleasedVolume)
+	}
+
+	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate validates the snapshot settings and parses the timeout
+func (c *ConfigVolumeSnapshot) Validate() error {
+	c.timeout = VolumeSnapshotDefaultTimeout
+	if c.Timeout != "" {
+		val, err := duration.Parse(c.Timeout)
+		if err != nil {
+			return fmt.Errorf(`unable to parse snapshot timeout "%s": %w`, c.Timeout, err)
+		}
+		c.timeout = val
+	}
+
+	return nil
+}
+
+// Validate validates all resources of the list
+func (c ConfigResourceList) Validate() error {
+	for _, resource := range c {
+		if err := resource.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the resource
+func (c *ConfigResource) Validate() error {
+	switch c.TimestampSource {
+	case "":
+		// default: timestampPath if set, otherwise creationTimestamp
+	case TimestampSourceTimestampPath:
+		if c.TimestampPath.IsEmpty() {
+			return fmt.Errorf(`resource "%s": timestampSource %s requires a timestampPath`, c.String(), TimestampSourceTimestampPath)
+		}
+	case TimestampSourceCreationTimestamp, TimestampSourceManagedFields, TimestampSourcePods, TimestampSourceLastUsed:
+		if !c.TimestampPath.IsEmpty() {
+			return fmt.Errorf(`resource "%s": timestampPath can only be used with timestampSource %s`, c.String(), TimestampSourceTimestampPath)
+		}
+	default:
+		return fmt.Errorf(
+			`resource "%s": timestampSource must be %s, %s, %s, %s or %s`,
+			c.String(),
+			TimestampSourceCreationTimestamp,
+			TimestampSourceTimestampPath,
+			TimestampSourceManagedFields,
+			TimestampSourcePods,
+			TimestampSourceLastUsed,
+		)
+	}
+
+	return c.validateFieldSelector()
+}
+
+// Clone clones the object
+func (c *ConfigResource) Clone() *ConfigResource {
+	// shallow copy, keeps the compiled JMES paths and patterns (immutable after config load)
+	ret := *c
 	return &ret
 }
 
@@ -144,10 +991,26 @@ func (c *ConfigResource) AsGVR() schema.GroupVersionResource {
 	}
 }
 
+func (c *ConfigHelmRule) String() string {
+	return c.Id
+}
+
 func (c *ConfigRule) String() string {
 	return c.Id
 }
 
+func (c *ConfigNamespaceRule) String() string {
+	return c.Id
+}
+
+func (c *ConfigOrphanRule) String() string {
+	return c.Id
+}
+
+func (c *ConfigVolumeRule) String() string {
+	return c.Id
+}
+
 // IsEmpty checks if the selector is empty/defined or not
 func (selector *ConfigLabelSelector) IsEmpty() bool {
 	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
@@ -180,6 +1043,19 @@ func (selector *ConfigLabelSelector) Compile() (string, error) {
 	return *selector.selector, nil
 }
 
+// AsDeleteOptions converts the config delete options to Kubernetes DeleteOptions
+func (o *ConfigRuleDeleteOptions) AsDeleteOptions() metav1.DeleteOptions {
+	deleteOpts := metav1.DeleteOptions{}
+	if o.PropagationPolicy != nil {
+		propagationPolicy := metav1.DeletionPropagation(*o.PropagationPolicy)
+		deleteOpts.PropagationPolicy = &propagationPolicy
+	}
+	if o.GracePeriodSeconds != nil {
+		deleteOpts.GracePeriodSeconds = o.GracePeriodSeconds
+	}
+	return deleteOpts
+}
+
 // validate validates the deletion PropagationPolicy
 func (p *ConfigRuleDeletePropagationPolicy) validate() error {
 	if p == nil {
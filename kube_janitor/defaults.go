@@ -0,0 +1,92 @@
+package kube_janitor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// validateResourceGroups validates the resources of all resource groups
+func (c *Config) validateResourceGroups() error {
+	for name, resources := range c.ResourceGroups {
+		if name == "" {
+			return errors.New("resource group requires a name")
+		}
+
+		if len(resources) == 0 {
+			return fmt.Errorf(`resource group "%s": requires at least one resource`, name)
+		}
+
+		if err := resources.Validate(); err != nil {
+			return fmt.Errorf(`resource group "%s": %w`, name, err)
+		}
+	}
+
+	return nil
+}
+
+// applyRuleDefaults resolves the resource groups of the rule and inherits all unset settings from the defaults,
+// needs to be called before the rule is validated
+func (c *Config) applyRuleDefaults(rule *ConfigRule) error {
+	if rule.defaultsApplied {
+		return nil
+	}
+	rule.defaultsApplied = true
+
+	// resource groups are added in front of the inline resources
+	resources := ConfigResourceList{}
+	for _, name := range rule.ResourceGroups {
+		group, exists := c.ResourceGroups[name]
+		if !exists {
+			return fmt.Errorf(`rule "%s": resource group "%s" is not defined`, rule.Id, name)
+		}
+		resources = append(resources, group...)
+	}
+	rule.Resources = append(resources, rule.Resources...)
+
+	if c.Defaults == nil || rule.IgnoreDefaults {
+		return nil
+	}
+
+	if rule.NamespaceSelector.IsEmpty() && rule.Namespaces.IsEmpty() && rule.ExcludeNamespaces.IsEmpty() {
+		rule.NamespaceSelector = c.Defaults.NamespaceSelector
+	}
+
+	if rule.DeleteOptions.PropagationPolicy == nil {
+		rule.DeleteOptions.PropagationPolicy = c.Defaults.DeleteOptions.PropagationPolicy
+	}
+
+	if rule.DeleteOptions.GracePeriodSeconds == nil {
+		rule.DeleteOptions.GracePeriodSeconds = c.Defaults.DeleteOptions.GracePeriodSeconds
+	}
+
+	if rule.Action == nil {
+		rule.Action = c.Defaults.Action
+	}
+
+	if rule.Notification == nil {
+		rule.Notification = c.Defaults.Notification
+	}
+
+	return nil
+}
+
+// Validate validates the rule defaults
+func (c *ConfigRuleDefaults) Validate() error {
+	if c.Action != nil {
+		if err := c.Action.Validate(); err != nil {
+			return fmt.Errorf(`defaults: %w`, err)
+		}
+	}
+
+	if c.Notification != nil {
+		if err := c.Notification.Validate(); err != nil {
+			return fmt.Errorf(`defaults: %w`, err)
+		}
+	}
+
+	if err := c.DeleteOptions.PropagationPolicy.validate(); err != nil {
+		return fmt.Errorf(`defaults: %w`, err)
+	}
+
+	return nil
+}
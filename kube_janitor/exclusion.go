@@ -0,0 +1,149 @@
+package kube_janitor
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type (
+	// exclusionSet contains the resources matched by the exclude rules of the current run
+	exclusionSet struct {
+		resources map[types.UID][]*ConfigExcludeRule
+	}
+)
+
+// loadExclusions finds all resources matched by the exclude rules, these resources are skipped by the rules of this run
+func (j *Janitor) loadExclusions(ctx context.Context) error {
+	j.exclusions = nil
+	if len(j.config.Exclude) == 0 {
+		return nil
+	}
+
+	exclusions := &exclusionSet{
+		resources: map[types.UID][]*ConfigExcludeRule{},
+	}
+
+	for _, excludeRule := range j.config.Exclude {
+		count, err := j.matchExcludeRule(ctx, excludeRule, exclusions)
+		if err != nil {
+			return err
+		}
+
+		j.logger.Info("finished exclude rule", slog.String("rule", excludeRule.Id), slog.Int("resources", count))
+	}
+
+	j.exclusions = exclusions
+
+	return nil
+}
+
+// matchExcludeRule adds the resources matched by the exclude rule to the exclusion set and returns the count of added resources,
+// uses the same matching as static rules but without the rule logs and metrics (exclude rules are not rules)
+func (j *Janitor) matchExcludeRule(ctx context.Context, excludeRule *ConfigExcludeRule, exclusions *exclusionSet) (int, error) {
+	excludeLogger := j.logger.With(slog.String("exclusion", excludeRule.Id))
+
+	// cluster resources are not part of any namespace (same as static rules)
+	namespaced := !excludeRule.NamespaceSelector.IsEmpty() || !excludeRule.Namespaces.IsEmpty() || !excludeRule.ExcludeNamespaces.IsEmpty()
+
+	resourceList, err := j.kubeLookupGvkList(ctx, excludeRule.Resources, namespaced)
+	if err != nil {
+		return 0, err
+	}
+
+	namespaces := []string{KubeNoNamespace}
+	if namespaced {
+		namespaces = []string{}
+		err = j.kubeEachNamespace(ctx, excludeRule.NamespaceSelector, func(namespace corev1.Namespace) error {
+			if matchesIncludeExclude(namespace.Name, excludeRule.Namespaces, excludeRule.ExcludeNamespaces) {
+				namespaces = append(namespaces, namespace.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for _, namespace := range namespaces {
+		for _, resourceType := range resourceList {
+			err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace, resourceType.Selector, resourceType.FieldSelector, func(resource unstructured.Unstructured) error {
+				if !matchesIncludeExclude(resource.GetName(), resourceType.Names, resourceType.ExcludeNames) {
+					return nil
+				}
+
+				if !resourceType.FilterPath.IsEmpty() {
+					// better safe than sorry, resources are excluded if the JMES path fails
+					skipped, err := j.checkResourceIsSkippedFromJmesPath(resource, resourceType.FilterPath)
+					if err != nil {
+						excludeLogger.Warn(
+							"unable to evaluate filterPath, resource is excluded",
+							slog.String("namespace", resource.GetNamespace()),
+							slog.String("name", resource.GetName()),
+							slog.Any("error", err),
+						)
+					} else if skipped {
+						return nil
+					}
+				}
+
+				uid := resource.GetUID()
+				if !slices.Contains(exclusions.resources[uid], excludeRule) {
+					exclusions.resources[uid] = append(exclusions.resources[uid], excludeRule)
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				excludeLogger.Error("failed to list resources", slog.String("namespace", namespace), slog.String("groupVersionKind", resourceType.String()), slog.Any("error", err))
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// isResourceExcluded checks if the resource is excluded from the rule, excluded resources are counted as skipped
+func (j *Janitor) isResourceExcluded(logger *slogger.Logger, ruleId, groupVersionKind string, resource metav1.Object) bool {
+	exclusion := j.exclusions.find(ruleId, resource)
+	if exclusion == nil {
+		return false
+	}
+
+	logger.Debug(
+		"resource is excluded",
+		slog.String("name", resource.GetName()),
+		slog.String("exclusion", exclusion.Id),
+	)
+	j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": ruleId, "groupVersionKind": groupVersionKind, "reason": MetricSkipReasonExcluded}).Inc()
+
+	return true
+}
+
+// find returns the exclude rule which excludes the resource from the rule, returns nil if the resource is not excluded
+func (e *exclusionSet) find(ruleId string, resource metav1.Object) *ConfigExcludeRule {
+	if e == nil {
+		return nil
+	}
+
+	for _, excludeRule := range e.resources[resource.GetUID()] {
+		if excludeRule.appliesTo(ruleId) {
+			return excludeRule
+		}
+	}
+
+	return nil
+}
+
+// appliesTo checks if the exclude rule applies to the rule (all rules if no rule ids are set)
+func (c *ConfigExcludeRule) appliesTo(ruleId string) bool {
+	return len(c.Rules) == 0 || slices.Contains(c.Rules, ruleId)
+}
@@ -0,0 +1,92 @@
+package kube_janitor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestLoadExclusions(t *testing.T) {
+	configMap := func(name string, uid types.UID) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("test")
+		obj.SetName(name)
+		obj.SetUID(uid)
+		return obj
+	}
+
+	j := newTestJanitor(t, configMap("protected-config", "1a"), configMap("config", "2b"))
+	j.config.Exclude = []*ConfigExcludeRule{
+		{
+			Id:    "ProtectedConfigMaps",
+			Rules: []string{"CleanupConfigMaps"},
+			Resources: ConfigResourceList{
+				{Version: "v1", Kind: "configmaps", Names: ConfigPatternList{{Pattern: "protected-*"}}},
+			},
+		},
+	}
+
+	if err := j.loadExclusions(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rule := &ConfigRule{Id: "CleanupConfigMaps"}
+	if exclusion := j.exclusions.find(rule.Id, configMap("protected-config", "1a")); exclusion == nil || exclusion.Id != "ProtectedConfigMaps" {
+		t.Fatalf("protected-config is not excluded: %v", exclusion)
+	}
+
+	if exclusion := j.exclusions.find(rule.Id, configMap("config", "2b")); exclusion != nil {
+		t.Fatalf("config is excluded by %s", exclusion.Id)
+	}
+
+	if exclusion := j.exclusions.find("OtherRule", configMap("protected-config", "1a")); exclusion != nil {
+		t.Fatalf("protected-config is excluded for other rule by %s", exclusion.Id)
+	}
+
+	// exclude rules are not rules, no rule metrics
+	families, err := j.registerer.(*prometheus.Registry).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "kube_janitor_resource_scanned_total" {
+			t.Fatalf("exclude rule reported %v resource scanned series", len(family.GetMetric()))
+		}
+	}
+}
+
+func TestConfigExcludeRuleIds(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rules   []string
+		wantErr bool
+	}{
+		{name: "all rules"},
+		{name: "static rule", rules: []string{"CleanupConfigMaps"}},
+		{name: "ttl rule", rules: []string{RuleIdInternalTTL}},
+		{name: "namespace rule", rules: []string{"CleanupPreviewNamespaces"}},
+		{name: "orphan rule", rules: []string{"CleanupOrphanedConfigMaps"}},
+		{name: "cluster rule", rules: []string{"CleanupStagingPods"}},
+		{name: "unknown rule", rules: []string{"CleanupConfigMaps", "CleanupConfigmaps"}, wantErr: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := NewConfig()
+			config.Rules = []*ConfigRule{{Id: "CleanupConfigMaps"}}
+			config.Namespaces = []*ConfigNamespaceRule{{Id: "CleanupPreviewNamespaces"}}
+			config.Orphans = []*ConfigOrphanRule{{Id: "CleanupOrphanedConfigMaps"}}
+			config.Clusters = []*ConfigCluster{{Name: "staging", Rules: []*ConfigRule{{Id: "CleanupStagingPods"}}}}
+			config.Exclude = []*ConfigExcludeRule{{Id: "ProtectedConfigMaps", Rules: testCase.rules}}
+
+			if err := config.validateExcludeRuleIds(); (err != nil) != testCase.wantErr {
+				t.Fatalf("error: got %v, want error %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
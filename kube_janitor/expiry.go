@@ -7,6 +7,16 @@ import (
 	"time"
 
 	"fortio.org/duration"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type (
+	// ExpiryExtension contains the extensions (snooze) of a resource expiry
+	ExpiryExtension struct {
+		Extend      []time.Duration
+		SnoozeUntil *time.Time
+		MaxLifetime time.Duration
+	}
 )
 
 var (
@@ -55,7 +65,8 @@ func (j *Janitor) parseTimestamp(val string) *time.Time {
 }
 
 // parseTimestamp checks an expiry string (unixtimestmap, duration or datetime string) against a timestamp, returns the parsed timestamp, if the timestamp is expired and possible errors
-func (j *Janitor) checkExpiryDate(createdAt time.Time, expiry string) (parsedTime *time.Time, expired bool, err error) {
+// the (optional) extension is added on top of the parsed expiry
+func (j *Janitor) checkExpiryDate(createdAt time.Time, expiry string, extension *ExpiryExtension) (parsedTime *time.Time, expired bool, err error) {
 	expired = false
 
 	// sanity checks
@@ -88,6 +99,12 @@ func (j *Janitor) checkExpiryDate(createdAt time.Time, expiry string) (parsedTim
 		}
 	}
 
+	// add extensions (snooze)
+	if parsedTime != nil && extension != nil {
+		extendedTime := extension.apply(createdAt, *parsedTime)
+		parsedTime = &extendedTime
+	}
+
 	// check if time could be parsed
 	if parsedTime != nil {
 		// check if parsed time is before NOW -> expired
@@ -98,3 +115,71 @@ func (j *Janitor) checkExpiryDate(createdAt time.Time, expiry string) (parsedTim
 
 	return
 }
+
+// Count returns the number of extensions
+func (e *ExpiryExtension) Count() int {
+	count := len(e.Extend)
+	if e.SnoozeUntil != nil {
+		count++
+	}
+	return count
+}
+
+// apply adds the extensions to the expiry, the extended expiry is capped by the max lifetime (against createdAt)
+func (e *ExpiryExtension) apply(createdAt, expiry time.Time) time.Time {
+	extendedExpiry := expiry
+	for _, val := range e.Extend {
+		extendedExpiry = extendedExpiry.Add(val)
+	}
+
+	if e.SnoozeUntil != nil && e.SnoozeUntil.After(extendedExpiry) {
+		extendedExpiry = *e.SnoozeUntil
+	}
+
+	// extensions must not exceed the max lifetime, but never shorten the original expiry
+	if e.MaxLifetime > 0 && !createdAt.IsZero() {
+		maxExpiry := createdAt.Add(e.MaxLifetime)
+		if extendedExpiry.After(maxExpiry) {
+			extendedExpiry = maxExpiry
+		}
+
+		if extendedExpiry.Before(expiry) {
+			extendedExpiry = expiry
+		}
+	}
+
+	return extendedExpiry
+}
+
+// parseResourceExpiryExtension parses the extension (ttl-extend, comma separated durations) and snooze (snooze-until, timestamp) annotations of a resource
+func (j *Janitor) parseResourceExpiryExtension(resource unstructured.Unstructured, maxLifetime time.Duration) (*ExpiryExtension, error) {
+	extension := &ExpiryExtension{
+		MaxLifetime: maxLifetime,
+	}
+	annotations := resource.GetAnnotations()
+
+	if val, exists := annotations[j.config.Annotations.TtlExtend]; exists {
+		for _, part := range strings.Split(val, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			extendDuration, err := duration.Parse(part)
+			if err != nil || extendDuration <= 0 {
+				return nil, fmt.Errorf(`unable to parse ttl extension '%s'`, part)
+			}
+			extension.Extend = append(extension.Extend, extendDuration)
+		}
+	}
+
+	if val, exists := annotations[j.config.Annotations.SnoozeUntil]; exists {
+		snoozeUntil := j.parseTimestamp(val)
+		if snoozeUntil == nil {
+			return nil, fmt.Errorf(`unable to parse snooze until '%s'`, val)
+		}
+		extension.SnoozeUntil = snoozeUntil
+	}
+
+	return extension, nil
+}
@@ -0,0 +1,84 @@
+package kube_janitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryExtensionApply(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiry := createdAt.Add(24 * time.Hour)
+	timestamp := func(offset time.Duration) *time.Time {
+		val := createdAt.Add(offset)
+		return &val
+	}
+
+	testCases := []struct {
+		name       string
+		extension  ExpiryExtension
+		expiry     time.Time
+		wantExpiry time.Time
+	}{
+		{
+			name:       "no extension",
+			expiry:     expiry,
+			wantExpiry: expiry,
+		},
+		{
+			name:       "ttl-extend adds all extensions",
+			extension:  ExpiryExtension{Extend: []time.Duration{24 * time.Hour, 12 * time.Hour}},
+			expiry:     expiry,
+			wantExpiry: expiry.Add(36 * time.Hour),
+		},
+		{
+			name:       "snooze-until after expiry",
+			extension:  ExpiryExtension{SnoozeUntil: timestamp(72 * time.Hour)},
+			expiry:     expiry,
+			wantExpiry: createdAt.Add(72 * time.Hour),
+		},
+		{
+			name:       "snooze-until before expiry doesn't shorten",
+			extension:  ExpiryExtension{SnoozeUntil: timestamp(1 * time.Hour)},
+			expiry:     expiry,
+			wantExpiry: expiry,
+		},
+		{
+			name:       "snooze-until after extended expiry wins",
+			extension:  ExpiryExtension{Extend: []time.Duration{24 * time.Hour}, SnoozeUntil: timestamp(96 * time.Hour)},
+			expiry:     expiry,
+			wantExpiry: createdAt.Add(96 * time.Hour),
+		},
+		{
+			name:       "extension capped by max lifetime",
+			extension:  ExpiryExtension{Extend: []time.Duration{7 * 24 * time.Hour}, MaxLifetime: 72 * time.Hour},
+			expiry:     expiry,
+			wantExpiry: createdAt.Add(72 * time.Hour),
+		},
+		{
+			name:       "snooze-until capped by max lifetime",
+			extension:  ExpiryExtension{SnoozeUntil: timestamp(30 * 24 * time.Hour), MaxLifetime: 72 * time.Hour},
+			expiry:     expiry,
+			wantExpiry: createdAt.Add(72 * time.Hour),
+		},
+		{
+			name:       "extension below max lifetime",
+			extension:  ExpiryExtension{Extend: []time.Duration{24 * time.Hour}, MaxLifetime: 72 * time.Hour},
+			expiry:     expiry,
+			wantExpiry: expiry.Add(24 * time.Hour),
+		},
+		{
+			name:       "expiry after max lifetime is not shortened",
+			extension:  ExpiryExtension{Extend: []time.Duration{24 * time.Hour}, MaxLifetime: 12 * time.Hour},
+			expiry:     expiry,
+			wantExpiry: expiry,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := testCase.extension.apply(createdAt, testCase.expiry); !got.Equal(testCase.wantExpiry) {
+				t.Fatalf("expiry: got %v, want %v", got, testCase.wantExpiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package kube_janitor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+var (
+	// kubeFieldSelectorCommonFields are supported by all resources
+	kubeFieldSelectorCommonFields = []string{"metadata.name", "metadata.namespace"}
+
+	// kubeFieldSelectorFields are the additional server-side fields of the built-in resources (group/resource)
+	kubeFieldSelectorFields = map[string][]string{
+		"/pods": {
+			"spec.nodeName", "spec.restartPolicy", "spec.schedulerName", "spec.serviceAccountName", "spec.hostNetwork",
+			"status.phase", "status.podIP", "status.podIPs", "status.nominatedNodeName",
+		},
+		"/events": {
+			"involvedObject.kind", "involvedObject.namespace", "involvedObject.name", "involvedObject.uid",
+			"involvedObject.apiVersion", "involvedObject.resourceVersion", "involvedObject.fieldPath",
+			"reason", "reportingComponent", "source", "type",
+		},
+		"/secrets":                {"type"},
+		"/namespaces":             {"status.phase"},
+		"/nodes":                  {"spec.unschedulable"},
+		"/replicationcontrollers": {"status.replicas"},
+		"apps/replicasets":        {"status.replicas"},
+		"batch/jobs":              {"status.successful"},
+		"certificates.k8s.io/certificatesigningrequests": {"spec.signerName"},
+	}
+)
+
+// validateFieldSelector checks the syntax of the field selector and if the fields are supported server-side,
+// wildcard resources only support the common fields, unknown resources (eg. CRDs with selectableFields) are not checked
+func (c *ConfigResource) validateFieldSelector() error {
+	if c.FieldSelector == "" {
+		return nil
+	}
+
+	selector, err := fields.ParseSelector(c.FieldSelector)
+	if err != nil {
+		return fmt.Errorf(`resource "%s": invalid fieldSelector "%s": %w`, c.String(), c.FieldSelector, err)
+	}
+
+	var supportedFields []string
+	if c.Group == "*" || c.Kind == "*" {
+		supportedFields = kubeFieldSelectorCommonFields
+	} else if resourceFields, exists := kubeFieldSelectorFields[c.Group+"/"+strings.ToLower(c.Kind)]; exists {
+		supportedFields = append(slices.Clone(kubeFieldSelectorCommonFields), resourceFields...)
+	} else {
+		return nil
+	}
+
+	for _, requirement := range selector.Requirements() {
+		if !slices.Contains(supportedFields, requirement.Field) {
+			return fmt.Errorf(
+				`resource "%s": field "%s" is not supported by fieldSelector (supported: %s)`,
+				c.String(),
+				requirement.Field,
+				strings.Join(supportedFields, ", "),
+			)
+		}
+	}
+
+	return nil
+}
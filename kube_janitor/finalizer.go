@@ -0,0 +1,144 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	FinalizersDefaultStuckAfter = 1 * time.Hour
+
+	ActionTypeRemoveFinalizers = "removeFinalizers"
+)
+
+// checkResourceTerminating checks if a terminating resource is stuck (deletionTimestamp older than stuckAfter),
+// reports it as metric and Warning event and removes the allowed finalizers after the remove grace period (if configured)
+func (j *Janitor) checkResourceTerminating(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule) {
+	deletionTimestamp := resource.GetDeletionTimestamp()
+	if deletionTimestamp == nil || rule.Finalizers == nil {
+		return
+	}
+
+	resourceLogger := logger.WithGroup("resource").With(
+		slog.String("namespace", resource.GetNamespace()),
+		slog.String("name", resource.GetName()),
+	)
+
+	terminatingDuration := time.Since(deletionTimestamp.Time)
+	if terminatingDuration <= rule.Finalizers.stuckAfter {
+		resourceLogger.Debug("resource is terminating", slog.Duration("duration", terminatingDuration))
+		return
+	}
+
+	groupVersionKind := resource.GroupVersionKind()
+	j.prometheus.resourceTerminating.With(
+		prometheus.Labels{
+			"rule":             rule.Id,
+			"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+			"namespace":        resource.GetNamespace(),
+			"name":             resource.GetName(),
+		},
+	).Set(float64(deletionTimestamp.Unix()))
+
+	finalizers := resource.GetFinalizers()
+	resourceLogger.Warn("resource is stuck in terminating", slog.Duration("duration", terminatingDuration), slog.Any("finalizers", finalizers))
+
+	message := fmt.Sprintf(`resource is terminating since %v (%s)`, terminatingDuration.Round(time.Second), rule.Id)
+	if len(finalizers) > 0 {
+		message += fmt.Sprintf(`, blocked by finalizers: %s`, strings.Join(finalizers, ", "))
+	}
+
+	if err := j.kubeCreateEventFromResourceWithType(ctx, resource.GetNamespace(), resource, KubeEventTypeWarning, KubeEventActionTerminating, message, "TerminationStuck"); err != nil {
+		resourceLogger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+	}
+
+	// forced removal of finalizers (second grace period after the resource is stuck)
+	if rule.Finalizers.Remove == nil || len(finalizers) == 0 {
+		return
+	}
+
+	if terminatingDuration <= rule.Finalizers.stuckAfter+rule.Finalizers.Remove.after {
+		return
+	}
+
+	removable := []string{}
+	remaining := []string{}
+	for _, finalizer := range finalizers {
+		if rule.Finalizers.isRemovalAllowed(finalizer) {
+			removable = append(removable, finalizer)
+		} else {
+			remaining = append(remaining, finalizer)
+		}
+	}
+
+	if len(removable) == 0 {
+		resourceLogger.Debug("no finalizer of the resource is allowed to be removed", slog.Any("finalizers", finalizers))
+		return
+	}
+
+	finalizerLogger := resourceLogger.With(slog.Any("finalizers", removable))
+
+	if j.dryRun {
+		finalizerLogger.Info("resource is stuck in terminating, would remove finalizers (DRY-RUN)")
+		j.audit(rule.Id, ActionTypeRemoveFinalizers, resource, "", nil, false, nil)
+		return
+	}
+
+	finalizerLogger.Warn("resource is stuck in terminating, removing finalizers")
+	err := j.kubeRemoveResourceFinalizers(ctx, resourceConfig, resource, remaining)
+	j.audit(rule.Id, ActionTypeRemoveFinalizers, resource, "", nil, err == nil, err)
+	if err != nil {
+		finalizerLogger.Error("unable to remove finalizers", slog.Any("error", err))
+		return
+	}
+
+	j.prometheus.action.With(
+		prometheus.Labels{
+			"rule":             rule.Id,
+			"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+			"namespace":        resource.GetNamespace(),
+			"action":           ActionTypeRemoveFinalizers,
+		},
+	).Inc()
+
+	message = fmt.Sprintf(`resource is terminating since %v, finalizers were removed: %s (%s)`, terminatingDuration.Round(time.Second), strings.Join(removable, ", "), rule.Id)
+	if err := j.kubeCreateEventFromResourceWithType(ctx, resource.GetNamespace(), resource, KubeEventTypeWarning, "FinalizersRemoved", message, "FinalizersRemoved"); err != nil {
+		finalizerLogger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+	}
+}
+
+// kubeRemoveResourceFinalizers replaces the finalizers with the remaining ones,
+// the JSON patch fails if the finalizers were changed in the meantime
+func (j *Janitor) kubeRemoveResourceFinalizers(ctx context.Context, resourceConfig *ConfigResource, resource unstructured.Unstructured, remaining []string) error {
+	patch := []map[string]interface{}{
+		{"op": "test", "path": "/metadata/finalizers", "value": resource.GetFinalizers()},
+		{"op": "replace", "path": "/metadata/finalizers", "value": remaining},
+	}
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.dynClient.Resource(resourceConfig.AsGVR()).Namespace(resource.GetNamespace()).Patch(ctx, resource.GetName(), types.JSONPatchType, patchData, metav1.PatchOptions{FieldManager: KubeFieldManager})
+	return err
+}
+
+// isRemovalAllowed checks if the finalizer matches the allow list (supports wildcards, eg. example.com/*)
+func (c *ConfigFinalizers) isRemovalAllowed(finalizer string) bool {
+	return slices.ContainsFunc(c.Remove.Allow, func(pattern string) bool {
+		matched, _ := path.Match(pattern, finalizer)
+		return matched
+	})
+}
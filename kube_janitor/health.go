@@ -0,0 +1,88 @@
+package kube_janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	HealthDefaultIntervalMultiplier = 3
+
+	// healthStartDelay is the delay of the first run (see Start)
+	healthStartDelay = 10 * time.Second
+)
+
+type (
+	// HealthCheck is the result of one health check
+	HealthCheck struct {
+		Name  string
+		Error error
+
+		// Optional checks are reported but don't fail the endpoint (eg. api server of one cluster in multi-cluster mode)
+		Optional bool
+	}
+)
+
+// Failed returns true if the check failed and is not optional
+func (c HealthCheck) Failed() bool {
+	return c.Error != nil && !c.Optional
+}
+
+// SetHealthIntervalMultiplier sets the multiple of the run interval after which the janitor is not live anymore if no run finished
+func (j *Janitor) SetHealthIntervalMultiplier(multiplier float64) *Janitor {
+	j.healthIntervalMultiplier = multiplier
+	return j
+}
+
+// healthCheckName adds the cluster to the check name (multi-cluster mode)
+func (j *Janitor) healthCheckName(name string) string {
+	if j.cluster != "" {
+		return fmt.Sprintf("%s[%s]", name, j.cluster)
+	}
+	return name
+}
+
+// LivenessChecks checks if the background run loop (see Start) is not stuck
+func (j *Janitor) LivenessChecks() []HealthCheck {
+	check := HealthCheck{Name: j.healthCheckName("runLoop")}
+
+	if j.startTime.IsZero() {
+		// background loop not started (eg. once mode)
+		return []HealthCheck{check}
+	}
+
+	lastRun := j.startTime.Add(healthStartDelay)
+	if lastRunFinished := j.lastRunFinished.Load(); lastRunFinished > 0 {
+		lastRun = time.Unix(0, lastRunFinished)
+	}
+
+	maxAge := time.Duration(float64(j.interval) * j.healthIntervalMultiplier)
+	if age := time.Since(lastRun); age > maxAge {
+		check.Error = fmt.Errorf("no run finished since %v (max %v)", age.Round(time.Second), maxAge)
+	}
+
+	return []HealthCheck{check}
+}
+
+// ReadinessChecks checks if the config is loaded and the Kubernetes api server is reachable
+func (j *Janitor) ReadinessChecks(ctx context.Context) []HealthCheck {
+	configCheck := HealthCheck{Name: j.healthCheckName("config")}
+	if j.config == nil {
+		configCheck.Error = errors.New("config not loaded")
+	}
+
+	apiServerCheck := HealthCheck{
+		Name: j.healthCheckName("apiserver"),
+		// one cluster must not affect the readiness of the whole janitor (multi-cluster mode)
+		Optional: j.cluster != "",
+	}
+	if j.kubeClient == nil {
+		apiServerCheck.Error = errors.New("not connected")
+	} else if err := j.kubeClient.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		apiServerCheck.Error = err
+	}
+
+	return []HealthCheck{configCheck, apiServerCheck}
+}
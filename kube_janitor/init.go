@@ -7,4 +7,6 @@ import (
 // init registers all yaml Unmarshaler
 func init() {
 	yaml.RegisterCustomUnmarshalerContext(UnmarshallJmesPath)
+	yaml.RegisterCustomUnmarshalerContext(UnmarshallConfigPattern)
+	yaml.RegisterCustomUnmarshalerContext(UnmarshallConfigRuleAction)
 }
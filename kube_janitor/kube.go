@@ -2,27 +2,64 @@ package kube_janitor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
 )
 
 const (
 	KubeDefaultListLimit = 100
 
+	KubeDefaultDiscoveryRefresh = 1 * time.Hour
+	KubeDiscoveryPartialRefresh = 1 * time.Minute
+	KubeDiscoveryWatchRetry     = 30 * time.Second
+
+	// watches closed faster are retried after KubeDiscoveryWatchRetry instead of resumed immediately
+	KubeDiscoveryWatchMinDuration = 1 * time.Second
+
+	kubeDiscoveryCacheKey = "kube.servergroups"
+
 	KubeNoNamespace = ""
 
+	KubeNoFieldSelector = ""
+
+	// resources created by the janitor itself (state, reports, snapshots)
+	KubeLabelManagedBy      = "app.kubernetes.io/managed-by"
+	KubeLabelManagedByValue = "kube-janitor"
+
+	// KubeFieldManager is the field manager of all writes of the janitor (identifies its own managedFields entries)
+	KubeFieldManager = "kube-janitor"
+
 	KubeSelectorError = "<error>"
 	KubeSelectorNone  = "<none>"
 
 	KubeVerbGet    = "get"
 	KubeVerbList   = "list"
 	KubeVerbDelete = "delete"
+
+	KubeEventNamespace = "default"
+
+	KubeEventTypeNormal  = "Normal"
+	KubeEventTypeWarning = "Warning"
+
+	KubeEventActionDeleted     = "Deleted"
+	KubeEventActionTerminating = "Terminating"
+)
+
+var (
+	kubeCustomResourceDefinitionGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
 )
 
 type (
@@ -31,64 +68,227 @@ type (
 	KubeServerGroupVersionKind struct {
 		metav1.GroupVersionKind
 		Namespaced bool
+
+		// ObjectKind is the kind of the objects (eg. Deployment), GroupVersionKind.Kind contains the resource name (eg. deployments)
+		ObjectKind string
+
+		// Preferred is true if the version is the preferred version of the api group
+		Preferred bool
+	}
+
+	kubeDiscovery struct {
+		gvkList KubeServerGroupVersionKindList
+
+		// failedGroups are the api groups which could not be discovered (partial discovery)
+		failedGroups []string
 	}
 )
 
-// kubeLookupGvkList fetches all GroupVersionKinds from the Kubernetes control plane (cached)
-func (j *Janitor) kubeDiscoverGVKs() (KubeServerGroupVersionKindList, error) {
-	cacheKey := "kube.servergroups"
+// kubeDiscoverGVKs fetches all GroupVersionKinds from the Kubernetes control plane (cached)
+func (j *Janitor) kubeDiscoverGVKs(ctx context.Context) (KubeServerGroupVersionKindList, error) {
+	discovery, err := j.kubeDiscover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return discovery.gvkList, nil
+}
 
+// kubeDiscover fetches all GroupVersionKinds (all served versions) from the Kubernetes control plane (cached),
+// unavailable api groups (eg. aggregated apis with failing backends) are logged and skipped
+func (j *Janitor) kubeDiscover(ctx context.Context) (*kubeDiscovery, error) {
 	// from cache
-	if val, ok := j.cache.Get(cacheKey); ok {
-		if v, ok := val.(KubeServerGroupVersionKindList); ok {
+	if val, ok := j.cache.Get(kubeDiscoveryCacheKey); ok {
+		if v, ok := val.(*kubeDiscovery); ok {
 			return v, nil
 		}
 	}
 
-	ret := KubeServerGroupVersionKindList{}
+	_, span := j.startSpan(ctx, "kube.discovery")
+	defer span.End()
+
+	ret := &kubeDiscovery{
+		gvkList: KubeServerGroupVersionKindList{},
+	}
 
 	j.logger.Info("discovering Kubernetes api groups and resources (GroupVersionKind)")
 
 	apiGroupsResult, apiResourcesResult, err := j.kubeClient.Discovery().ServerGroupsAndResources()
 	if err != nil {
-		return nil, err
+		var groupDiscoveryErr *discovery.ErrGroupDiscoveryFailed
+		if !errors.As(err, &groupDiscoveryErr) {
+			spanRecordError(span, err)
+			return nil, err
+		}
+
+		// partial discovery, use the available api groups
+		for groupVersion, groupErr := range groupDiscoveryErr.Groups {
+			ret.failedGroups = append(ret.failedGroups, groupVersion.String())
+			j.logger.Warn(
+				"unable to discover api group, skipping",
+				slog.String("groupVersion", groupVersion.String()),
+				slog.Any("error", groupErr),
+			)
+		}
+		slices.Sort(ret.failedGroups)
+		span.SetAttributes(TracingAttrFailedGroups.StringSlice(ret.failedGroups))
 	}
 
 	// build GVK list
 	// loop though all available api groups
 	for _, apiGroup := range apiGroupsResult {
-		// go though all the api resources (by api group)
+		// go though all the api resources (by api group) of all served versions
 		for _, apiResourceGroup := range apiResourcesResult {
-			// only use preferred version, we don't care about the others (old) versions
-			if apiResourceGroup.GroupVersion == apiGroup.PreferredVersion.GroupVersion {
-				for _, resource := range apiResourceGroup.APIResources {
-					// only select resources if we can get, list and delete it
-					// (otherwise it doesn't make sense)
-					resourceVerbs := []string(resource.Verbs)
-					if slices.Contains(resourceVerbs, KubeVerbGet) &&
-						slices.Contains(resourceVerbs, KubeVerbList) &&
-						slices.Contains(resourceVerbs, KubeVerbDelete) {
-						ret = append(ret, KubeServerGroupVersionKind{
-							GroupVersionKind: metav1.GroupVersionKind{
-								Group:   apiGroup.Name,
-								Version: apiGroup.PreferredVersion.Version,
-								Kind:    resource.Name,
-							},
-							Namespaced: resource.Namespaced,
-						})
-					}
+			idx := slices.IndexFunc(apiGroup.Versions, func(version metav1.GroupVersionForDiscovery) bool {
+				return version.GroupVersion == apiResourceGroup.GroupVersion
+			})
+			if idx < 0 {
+				continue
+			}
+			version := apiGroup.Versions[idx]
+
+			for _, resource := range apiResourceGroup.APIResources {
+				// only select resources if we can get, list and delete it
+				// (otherwise it doesn't make sense)
+				resourceVerbs := []string(resource.Verbs)
+				if slices.Contains(resourceVerbs, KubeVerbGet) &&
+					slices.Contains(resourceVerbs, KubeVerbList) &&
+					slices.Contains(resourceVerbs, KubeVerbDelete) {
+					ret.gvkList = append(ret.gvkList, KubeServerGroupVersionKind{
+						GroupVersionKind: metav1.GroupVersionKind{
+							Group:   apiGroup.Name,
+							Version: version.Version,
+							Kind:    resource.Name,
+						},
+						Namespaced: resource.Namespaced,
+						ObjectKind: resource.Kind,
+						Preferred:  version.GroupVersion == apiGroup.PreferredVersion.GroupVersion,
+					})
 				}
 			}
 		}
 	}
 
-	j.cache.SetDefault(cacheKey, ret)
+	// partial results are only cached shortly, failing api groups might be available again soon
+	cacheDuration := j.kubeDiscoveryRefresh
+	if len(ret.failedGroups) > 0 {
+		cacheDuration = min(cacheDuration, KubeDiscoveryPartialRefresh)
+	}
+	j.cache.Set(kubeDiscoveryCacheKey, ret, cacheDuration)
+	span.SetAttributes(TracingAttrItems.Int(len(ret.gvkList)))
 
 	return ret, nil
 }
 
+// kubeInvalidateDiscovery removes the discovered GroupVersionKinds from the cache, the next lookup discovers them again
+func (j *Janitor) kubeInvalidateDiscovery() {
+	j.cache.Delete(kubeDiscoveryCacheKey)
+}
+
+// startKubeDiscoveryWatch starts the background watch of CustomResourceDefinitions (once, needs a connection)
+// which invalidates the discovery cache if CRDs are created or deleted, the watch ends with the janitor (Stop)
+func (j *Janitor) startKubeDiscoveryWatch() {
+	if j.kubeDiscoveryWatchStarted {
+		return
+	}
+
+	// connection failures are reported by the run
+	if err := j.connect(); err != nil {
+		return
+	}
+	j.kubeDiscoveryWatchStarted = true
+
+	go func() {
+		ctx := j.ctx
+		resourceVersion := ""
+		for {
+			var err error
+			watchStart := time.Now()
+			resourceVersion, err = j.kubeWatchCustomResourceDefinitions(ctx, resourceVersion)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case apierrors.IsForbidden(err):
+				j.logger.Warn(
+					"not allowed to watch CustomResourceDefinitions, discovery is only refreshed by interval",
+					slog.Any("error", err),
+				)
+				return
+			case apierrors.IsResourceExpired(err) || apierrors.IsGone(err):
+				// resourceVersion is too old to resume, events might be missed until the watch starts again
+				j.logger.Info("watch of CustomResourceDefinitions expired, invalidating discovery cache", slog.Any("error", err))
+				j.kubeInvalidateDiscovery()
+				resourceVersion = ""
+				continue
+			case err != nil:
+				j.logger.Warn("watch of CustomResourceDefinitions failed, retrying", slog.Any("error", err))
+			case time.Since(watchStart) >= KubeDiscoveryWatchMinDuration:
+				// watch was closed by the server (timeout), resumed from the last resourceVersion
+				continue
+			default:
+				// watch was closed immediately, avoid a tight loop
+				j.logger.Debug("watch of CustomResourceDefinitions closed immediately, retrying", slog.Duration("retry", KubeDiscoveryWatchRetry))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(KubeDiscoveryWatchRetry):
+			}
+		}
+	}()
+}
+
+// kubeWatchCustomResourceDefinitions watches CustomResourceDefinitions from the resourceVersion (or from now if empty)
+// until the watch is closed, returns the last seen resourceVersion to resume the watch
+func (j *Janitor) kubeWatchCustomResourceDefinitions(ctx context.Context, resourceVersion string) (string, error) {
+	client := j.dynClient.Resource(kubeCustomResourceDefinitionGVR)
+
+	// only watch for changes after now
+	if resourceVersion == "" {
+		list, err := client.List(ctx, metav1.ListOptions{Limit: 1})
+		if err != nil {
+			return "", err
+		}
+		resourceVersion = list.GetResourceVersion()
+	}
+
+	watcher, err := client.Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true})
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer watcher.Stop()
+
+	for event := range watcher.ResultChan() {
+		if event.Type != watch.Error {
+			if obj, ok := event.Object.(*unstructured.Unstructured); ok && obj.GetResourceVersion() != "" {
+				resourceVersion = obj.GetResourceVersion()
+			}
+		}
+
+		switch event.Type {
+		case watch.Added, watch.Deleted:
+			crdName := ""
+			if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+				crdName = obj.GetName()
+			}
+
+			j.logger.Info(
+				"CustomResourceDefinition changed, invalidating discovery cache",
+				slog.String("event", string(event.Type)),
+				slog.String("name", crdName),
+			)
+			j.kubeInvalidateDiscovery()
+		case watch.Error:
+			return resourceVersion, apierrors.FromObject(event.Object)
+		}
+	}
+
+	return resourceVersion, nil
+}
+
 // kubeLookupGvkList looksup all GroupVersionKinds from the ConfigResourceList and fills in all wildcards
-func (j *Janitor) kubeLookupGvkList(list ConfigResourceList, namespaced bool) (ConfigResourceList, error) {
+func (j *Janitor) kubeLookupGvkList(ctx context.Context, list ConfigResourceList, namespaced bool) (ConfigResourceList, error) {
 	var (
 		gvrList KubeServerGroupVersionKindList
 		err     error
@@ -99,7 +299,7 @@ func (j *Janitor) kubeLookupGvkList(list ConfigResourceList, namespaced bool) (C
 		if resource.Group == "*" || resource.Version == "*" || resource.Kind == "*" {
 			// lookup possible types
 			if gvrList == nil {
-				gvrList, err = j.kubeDiscoverGVKs()
+				gvrList, err = j.kubeDiscoverGVKs(ctx)
 				if err != nil {
 					return nil, err
 				}
@@ -113,6 +313,10 @@ func (j *Janitor) kubeLookupGvkList(list ConfigResourceList, namespaced bool) (C
 				if resource.Group != "*" && !strings.EqualFold(resource.Group, serverGroupVersionKind.Group) {
 					continue
 				}
+				// wildcard matches only the preferred version, a specific version can also match non-preferred versions
+				if resource.Version == "*" && !serverGroupVersionKind.Preferred {
+					continue
+				}
 				if resource.Version != "*" && !strings.EqualFold(resource.Version, serverGroupVersionKind.Version) {
 					continue
 				}
@@ -136,22 +340,78 @@ func (j *Janitor) kubeLookupGvkList(list ConfigResourceList, namespaced bool) (C
 	return ret, nil
 }
 
+// kubeLookupResourceByKind maps the apiVersion and kind of an object (eg. from ownerReferences or manifests)
+// to the resource using discovery, returns nil if the kind is not available
+func (j *Janitor) kubeLookupResourceByKind(ctx context.Context, apiVersion, kind string) (*ConfigResource, bool, error) {
+	groupVersion, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, false, err
+	}
+
+	gvkList, err := j.kubeDiscoverGVKs(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// use the version of the object if it's served, otherwise the preferred version
+	var ret *KubeServerGroupVersionKind
+	for _, serverGroupVersionKind := range gvkList {
+		// ignore subresources
+		if strings.Contains(serverGroupVersionKind.Kind, "/") {
+			continue
+		}
+
+		if serverGroupVersionKind.Group != groupVersion.Group || serverGroupVersionKind.ObjectKind != kind {
+			continue
+		}
+
+		if serverGroupVersionKind.Version == groupVersion.Version {
+			ret = &serverGroupVersionKind
+			break
+		} else if serverGroupVersionKind.Preferred {
+			ret = &serverGroupVersionKind
+		}
+	}
+
+	if ret == nil {
+		return nil, false, nil
+	}
+
+	return &ConfigResource{
+		Group:   ret.Group,
+		Version: ret.Version,
+		Kind:    ret.Kind,
+	}, ret.Namespaced, nil
+}
+
 // kubeEachNamespace fetches all visible namespaces and executes a callback function
-func (j *Janitor) kubeEachNamespace(ctx context.Context, selector ConfigLabelSelector, callback func(namespace corev1.Namespace) error) error {
+func (j *Janitor) kubeEachNamespace(ctx context.Context, selector ConfigLabelSelector, callback func(namespace corev1.Namespace) error) (err error) {
 	labelSelector, err := selector.Compile()
 	if err != nil {
 		return err
 	}
 
+	ctx, span := j.startSpan(ctx, "kube.eachNamespace", TracingAttrGvr.String("/v1/namespaces"))
+	items, pages := 0, 0
+	defer func() {
+		span.SetAttributes(TracingAttrItems.Int(items), TracingAttrPages.Int(pages))
+		spanRecordError(span, err)
+		span.End()
+	}()
+
 	listOpts := metav1.ListOptions{
 		Limit:         j.kubePageLimit,
 		LabelSelector: labelSelector,
 	}
 	for {
+		startTime := time.Now()
 		result, err := j.kubeClient.CoreV1().Namespaces().List(ctx, listOpts)
+		j.prometheus.kubeListDuration.With(prometheus.Labels{"groupVersionResource": "/v1/namespaces"}).Observe(time.Since(startTime).Seconds())
 		if err != nil {
 			return err
 		}
+		items += len(result.Items)
+		pages++
 
 		for _, item := range result.Items {
 			err := callback(item)
@@ -171,16 +431,26 @@ func (j *Janitor) kubeEachNamespace(ctx context.Context, selector ConfigLabelSel
 	return nil
 }
 
-// kubeEachResource fetches all visible resources and executes a callback function, if namespace is empty string it fetches all resources cluster wide
-func (j *Janitor) kubeEachResource(ctx context.Context, gvr schema.GroupVersionResource, namespace string, selector ConfigLabelSelector, callback func(unstructured unstructured.Unstructured) error) error {
+// kubeEachResource fetches all visible resources (by label and field selector) and executes a callback function, if namespace is empty string it fetches all resources cluster wide
+func (j *Janitor) kubeEachResource(ctx context.Context, gvr schema.GroupVersionResource, namespace string, selector ConfigLabelSelector, fieldSelector string, callback func(unstructured unstructured.Unstructured) error) (err error) {
 	labelSelector, err := selector.Compile()
 	if err != nil {
 		return err
 	}
 
+	gvrLabel := fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource)
+	ctx, span := j.startSpan(ctx, "kube.eachResource", TracingAttrGvr.String(gvrLabel), TracingAttrNamespace.String(namespace))
+	items, pages := 0, 0
+	defer func() {
+		span.SetAttributes(TracingAttrItems.Int(items), TracingAttrPages.Int(pages))
+		spanRecordError(span, err)
+		span.End()
+	}()
+
 	listOpts := metav1.ListOptions{
 		Limit:         j.kubePageLimit,
 		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
 	}
 	for {
 		var (
@@ -188,6 +458,7 @@ func (j *Janitor) kubeEachResource(ctx context.Context, gvr schema.GroupVersionR
 			err    error
 		)
 
+		startTime := time.Now()
 		if namespace != KubeNoNamespace {
 			// get by namespace
 			result, err = j.dynClient.Resource(gvr).Namespace(namespace).List(ctx, listOpts)
@@ -195,10 +466,13 @@ func (j *Janitor) kubeEachResource(ctx context.Context, gvr schema.GroupVersionR
 			// get all
 			result, err = j.dynClient.Resource(gvr).List(ctx, listOpts)
 		}
+		j.prometheus.kubeListDuration.With(prometheus.Labels{"groupVersionResource": gvrLabel}).Observe(time.Since(startTime).Seconds())
 
 		if err != nil {
 			return err
 		}
+		items += len(result.Items)
+		pages++
 
 		for _, item := range result.Items {
 			err := callback(item)
@@ -218,37 +492,67 @@ func (j *Janitor) kubeEachResource(ctx context.Context, gvr schema.GroupVersionR
 	return nil
 }
 
-func (j *Janitor) kubeCreateEventFromResource(ctx context.Context, namespace string, resource unstructured.Unstructured, message, reason string) error {
+// kubeCreateEventFromResource creates a Kubernetes Event for a resource
+func (j *Janitor) kubeCreateEventFromResource(ctx context.Context, namespace string, resource unstructured.Unstructured, action, message, reason string) error {
+	return j.kubeCreateEventFromResourceWithType(ctx, namespace, resource, KubeEventTypeNormal, action, message, reason)
+}
+
+// kubeCreateEventFromResourceWithType creates a Kubernetes Event with the event type (Normal, Warning) for a resource
+func (j *Janitor) kubeCreateEventFromResourceWithType(ctx context.Context, namespace string, resource unstructured.Unstructured, eventType, action, message, reason string) error {
+	involvedObject := corev1.ObjectReference{
+		APIVersion: resource.GetAPIVersion(),
+		Kind:       resource.GetKind(),
+		Namespace:  resource.GetNamespace(),
+		Name:       resource.GetName(),
+		UID:        resource.GetUID(),
+	}
+
+	// cluster scoped resources
+	if namespace == KubeNoNamespace {
+		namespace = KubeEventNamespace
+	}
+
+	return j.kubeCreateEvent(ctx, namespace, involvedObject, eventType, action, message, reason)
+}
+
+// kubeCreateEventFromNamespace creates a Kubernetes Event for a namespace (inside the default namespace as namespaces are cluster scoped)
+func (j *Janitor) kubeCreateEventFromNamespace(ctx context.Context, namespace corev1.Namespace, eventType, action, message, reason string) error {
+	involvedObject := corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace.Name,
+		UID:        namespace.UID,
+	}
+
+	return j.kubeCreateEvent(ctx, KubeEventNamespace, involvedObject, eventType, action, message, reason)
+}
+
+// kubeCreateEvent creates a Kubernetes Event for the involved object
+func (j *Janitor) kubeCreateEvent(ctx context.Context, namespace string, involvedObject corev1.ObjectReference, eventType, action, message, reason string) error {
 	timestamp := metav1.Time{Time: time.Now()}
 
 	event := corev1.Event{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "kube-janitor-",
-			Namespace:    resource.GetNamespace(),
+			Namespace:    namespace,
 		},
 		ReportingInstance: "kube-janitor",
-		InvolvedObject: corev1.ObjectReference{
-			APIVersion: resource.GetAPIVersion(),
-			Kind:       resource.GetKind(),
-			Namespace:  resource.GetNamespace(),
-			Name:       resource.GetName(),
-			UID:        resource.GetUID(),
-		},
-		Reason:  reason,
-		Message: message,
+		InvolvedObject:    involvedObject,
+		Reason:            reason,
+		Message:           message,
 		Source: corev1.EventSource{
 			Component: "kube-janitor",
 		},
 		FirstTimestamp:      timestamp,
 		LastTimestamp:       timestamp,
 		Count:               1,
-		Type:                "Normal",
+		Type:                eventType,
 		Series:              nil,
-		Action:              "Deleted",
+		Action:              action,
 		Related:             nil,
 		ReportingController: "kube-janitor",
 	}
 
-	_, err := j.kubeClient.CoreV1().Events(namespace).Create(ctx, &event, metav1.CreateOptions{})
+	_, err := j.kubeClient.CoreV1().Events(namespace).Create(ctx, &event, metav1.CreateOptions{FieldManager: KubeFieldManager})
 	return err
 }
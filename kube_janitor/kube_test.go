@@ -0,0 +1,104 @@
+package kube_janitor
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	clientTesting "k8s.io/client-go/testing"
+)
+
+func TestKubeWatchCustomResourceDefinitionsResume(t *testing.T) {
+	crd := func(name, resourceVersion string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("apiextensions.k8s.io/v1")
+		obj.SetKind("CustomResourceDefinition")
+		obj.SetName(name)
+		obj.SetResourceVersion(resourceVersion)
+		return obj
+	}
+
+	testCases := []struct {
+		name                string
+		resourceVersion     string
+		events              []watch.Event
+		wantResourceVersion string
+		wantExpired         bool
+		wantInvalidated     bool
+	}{
+		{
+			name:            "resumed from last event and bookmark",
+			resourceVersion: "10",
+			events: []watch.Event{
+				{Type: watch.Added, Object: crd("foos.example.com", "11")},
+				{Type: watch.Bookmark, Object: crd("", "12")},
+			},
+			wantResourceVersion: "12",
+			wantInvalidated:     true,
+		},
+		{
+			name:            "modified crd doesn't invalidate discovery",
+			resourceVersion: "10",
+			events: []watch.Event{
+				{Type: watch.Modified, Object: crd("foos.example.com", "11")},
+			},
+			wantResourceVersion: "11",
+		},
+		{
+			name:            "expired resourceVersion",
+			resourceVersion: "10",
+			events: []watch.Event{
+				{Type: watch.Error, Object: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    410,
+					Reason:  metav1.StatusReasonExpired,
+					Message: "too old resource version",
+				}},
+			},
+			wantResourceVersion: "10",
+			wantExpired:         true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			j := newTestJanitor(t)
+			j.cache.Set(kubeDiscoveryCacheKey, &kubeDiscovery{}, 0)
+
+			requestedResourceVersion := ""
+			j.dynClient.(*dynamicFake.FakeDynamicClient).PrependWatchReactor("customresourcedefinitions", func(action clientTesting.Action) (bool, watch.Interface, error) {
+				requestedResourceVersion = action.(clientTesting.WatchActionImpl).GetWatchRestrictions().ResourceVersion
+
+				watcher := watch.NewFakeWithChanSize(len(testCase.events), false)
+				for _, event := range testCase.events {
+					watcher.Action(event.Type, event.Object)
+				}
+				watcher.Stop()
+				return true, watcher, nil
+			})
+
+			resourceVersion, err := j.kubeWatchCustomResourceDefinitions(context.Background(), testCase.resourceVersion)
+			if expired := apierrors.IsResourceExpired(err); expired != testCase.wantExpired {
+				t.Fatalf("watch expired: got %v, want %v (%v)", expired, testCase.wantExpired, err)
+			} else if !expired && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if requestedResourceVersion != testCase.resourceVersion {
+				t.Fatalf("watch resourceVersion: got %v, want %v", requestedResourceVersion, testCase.resourceVersion)
+			}
+
+			if resourceVersion != testCase.wantResourceVersion {
+				t.Fatalf("last resourceVersion: got %v, want %v", resourceVersion, testCase.wantResourceVersion)
+			}
+
+			if _, cached := j.cache.Get(kubeDiscoveryCacheKey); cached == testCase.wantInvalidated {
+				t.Fatalf("discovery invalidated: got %v, want %v", !cached, testCase.wantInvalidated)
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@ package kube_janitor
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
-	"os"
+	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
-	yaml "github.com/goccy/go-yaml"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/log/slogger"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -19,79 +24,165 @@ import (
 
 type (
 	Janitor struct {
-		kubeconfig string
+		kubeconfig  string
+		kubeContext string
+
+		// cluster is the name of the cluster in multi-cluster mode (empty in single cluster mode)
+		cluster string
+
+		// ctx is the lifetime of the janitor (runs and background watches), canceled by Stop
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		// clusterNames are the names of all clusters of the multi-cluster mode (validation of the cluster overrides)
+		clusterNames []string
 
 		config *Config
 
 		cache *cache.Cache
 
-		kubeClient *kubernetes.Clientset
-		dynClient  *dynamic.DynamicClient
+		state *stateStore
+
+		report          *RunReport
+		reportBackend   RunReportBackend
+		reportRetention ReportRetention
+
+		auditLogger *AuditLogger
+
+		kubeClient kubernetes.Interface
+		dynClient  dynamic.Interface
 
 		logger *slogger.Logger
 
 		dryRun bool
 
 		prometheus JanitorMetrics
+		registerer prometheus.Registerer
+
+		tracer         trace.Tracer
+		tracerProvider trace.TracerProvider
+
+		// exclusions and ruleOverruled are the exclude rule matches and the rule overlaps of the current run
+		exclusions    *exclusionSet
+		ruleOverruled map[string][]string
+
+		metricsMode            string
+		metricsNamespaces      []string
+		metricResourceExpiring *prometheusCommon.HashedMetricList
+
+		// metricResourceExtensions collects the extensions of the current run (per-resource series are replaced after every run)
+		metricResourceExtensions *prometheusCommon.MetricList
 
 		kubePageLimit int64
+
+		kubeDiscoveryRefresh      time.Duration
+		kubeDiscoveryWatchStarted bool
+
+		interval                 time.Duration
+		startTime                time.Time
+		lastRunFinished          atomic.Int64
+		healthIntervalMultiplier float64
 	}
 )
 
 // New creates a new Janitor instance
 func New() *Janitor {
-	j := &Janitor{}
+	j := &Janitor{
+		registerer: prometheus.DefaultRegisterer,
+	}
+	j.init()
+	return j
+}
+
+// NewForCluster creates a new Janitor instance for one cluster of the multi-cluster mode,
+// all metrics are registered with the label cluster and failed runs don't stop the other clusters
+func NewForCluster(cluster string) *Janitor {
+	j := &Janitor{
+		cluster:    cluster,
+		registerer: prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cluster}, prometheus.DefaultRegisterer),
+	}
 	j.init()
 	return j
 }
 
 // init initializes metrics and cache
 func (j *Janitor) init() {
+	j.ctx, j.cancel = context.WithCancel(context.Background())
 	j.setupMetrics()
+	j.metricsMode = MetricsModeResource
+	j.metricResourceExpiring = prometheusCommon.NewHashedMetricsList()
+	j.metricResourceExtensions = prometheusCommon.NewMetricsList()
 	j.cache = cache.New(1*time.Hour, 5*time.Minute)
+	j.state = newStateStore(NewStateBackendMemory())
 	j.kubePageLimit = KubeDefaultListLimit
+	j.kubeDiscoveryRefresh = KubeDefaultDiscoveryRefresh
+	j.reportRetention = ReportRetention{MaxAge: ReportDefaultMaxAge}
+	j.healthIntervalMultiplier = HealthDefaultIntervalMultiplier
 }
 
 // connect creates kubernetes client and the dynamic client
-func (j *Janitor) connect() {
+func (j *Janitor) connect() error {
 	if j.dynClient != nil {
-		return
+		return nil
 	}
 
 	var err error
 	var config *rest.Config
 
-	if j.kubeconfig != "" {
+	switch {
+	case j.kubeContext != "":
+		// context of KUBECONFIG
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if j.kubeconfig != "" {
+			loadingRules.ExplicitPath = j.kubeconfig
+		}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: j.kubeContext}).ClientConfig()
+		if err != nil {
+			return err
+		}
+	case j.kubeconfig != "":
 		// KUBECONFIG
 		config, err = clientcmd.BuildConfigFromFlags("", j.kubeconfig)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-	} else {
+	default:
 		// K8S in cluster
 		config, err = rest.InClusterConfig()
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 	}
 
-	j.kubeClient, err = kubernetes.NewForConfig(config)
+	// trace all Kubernetes API calls
+	if j.tracerProvider != nil {
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return otelhttp.NewTransport(rt, otelhttp.WithTracerProvider(j.tracerProvider))
+		})
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 
-	j.dynClient, err = dynamic.NewForConfig(config)
+	dynClient, err := dynamic.NewForConfig(config)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// kube logger (with translator)
 	logrHandler := logr.NewContextWithSlogLogger(context.Background(), j.logger.Slog())
 	kubeLogger, err := logr.FromContext(logrHandler)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 	kubelog.SetLogger(kubeLogger)
+
+	j.kubeClient = kubeClient
+	j.dynClient = dynClient
+
+	return nil
 }
 
 // SetKubeconfig sets the KUBECONFIG for the connection to the Kubernetes control plane
@@ -100,7 +191,20 @@ func (j *Janitor) SetKubeconfig(kubeconfig string) *Janitor {
 	return j
 }
 
-// LoadConfigFromFile loads the config file from the filesystem and parses it
+// SetKubeContext sets the context of the KUBECONFIG (multi-cluster mode)
+func (j *Janitor) SetKubeContext(kubeContext string) *Janitor {
+	j.kubeContext = kubeContext
+	return j
+}
+
+// SetClusterNames sets the names of all clusters of the multi-cluster mode,
+// needs to be set before the config is loaded
+func (j *Janitor) SetClusterNames(names []string) *Janitor {
+	j.clusterNames = names
+	return j
+}
+
+// LoadConfigFromFile loads the config from the filesystem (file, directory or glob, with includes) and parses it
 func (j *Janitor) LoadConfigFromFile(path string) *Janitor {
 	if j.config == nil {
 		j.config = NewConfig()
@@ -110,24 +214,25 @@ func (j *Janitor) LoadConfigFromFile(path string) *Janitor {
 
 	logger := j.logger.With(slog.String("path", path))
 
-	logger.Info("reading configuration from file")
-
-	/* #nosec */
-	data, err := os.ReadFile(path)
-	if err != nil {
-		logger.Fatal("failed to read config file", slog.Any("error", err.Error()))
-	}
-
-	logger.Info("parsing configuration")
-	err = yaml.UnmarshalContext(parserCtx, data, j.config, yaml.Strict(), yaml.UseJSONUnmarshaler())
+	err := newConfigLoader(j.logger, j.config).load(parserCtx, path)
 	if err != nil {
-		logger.Fatal("failed to parse config file", slog.Any("error", err.Error()))
+		logger.Fatal("failed to load config", slog.Any("error", err.Error()))
 	}
 
 	err = j.config.Validate()
 	if err != nil {
 		logger.Fatal("config validation failed", slog.Any("error", err))
 	}
+
+	// overrides for unknown clusters are ignored (eg. typo or removed cluster)
+	if unknown := j.config.UnknownClusters(j.clusterNames); len(unknown) > 0 {
+		logger.Warn("config contains overrides for unknown clusters, overrides are ignored", slog.Any("clusters", unknown))
+	}
+
+	if j.cluster != "" {
+		j.config = j.config.ForCluster(j.cluster)
+	}
+
 	return j
 }
 
@@ -143,44 +248,166 @@ func (j *Janitor) SetDryRun(val bool) *Janitor {
 	return j
 }
 
+// SetStateBackend sets the backend for the persistent state (first-seen and condition-since tracking)
+func (j *Janitor) SetStateBackend(backend StateBackend) *Janitor {
+	j.state = newStateStore(backend)
+	return j
+}
+
+// SetReportBackend sets the backend for the run reports and which reports are kept (max age and count)
+func (j *Janitor) SetReportBackend(backend RunReportBackend, retention ReportRetention) *Janitor {
+	j.reportBackend = backend
+	j.reportRetention = retention
+	return j
+}
+
+// SetAuditLogger sets the audit logger for destructive actions
+func (j *Janitor) SetAuditLogger(auditLogger *AuditLogger) *Janitor {
+	j.auditLogger = auditLogger
+	return j
+}
+
+// SetMetricsMode sets the mode of the per-resource expiry metrics (resource, aggregated or off),
+// resources inside the allowlisted namespaces are always exported per resource
+func (j *Janitor) SetMetricsMode(mode string, namespaces []string) *Janitor {
+	j.metricsMode = mode
+	j.metricsNamespaces = namespaces
+	return j
+}
+
+// SetTracerProvider sets the OpenTelemetry tracer provider for the spans of the runs and Kubernetes API calls (needs to be set before Connect)
+func (j *Janitor) SetTracerProvider(provider trace.TracerProvider) *Janitor {
+	j.tracerProvider = provider
+	j.tracer = provider.Tracer(TracingName)
+	return j
+}
+
 // SetKubePageSize sets the paging size
 func (j *Janitor) SetKubePageSize(val int64) *Janitor {
 	j.kubePageLimit = val
 	return j
 }
 
+// SetKubeDiscoveryRefresh sets how long the discovered api groups and resources are cached
+func (j *Janitor) SetKubeDiscoveryRefresh(val time.Duration) *Janitor {
+	j.kubeDiscoveryRefresh = val
+	return j
+}
+
 // Connects connects the janitor to the Kubernetes control plane
 func (j *Janitor) Connect() *Janitor {
-	j.connect()
+	if err := j.connect(); err != nil {
+		panic(err.Error())
+	}
 	return j
 }
 
 // Start starts the background endless janitor run
 func (j *Janitor) Start(interval time.Duration) *Janitor {
+	j.interval = interval
+	j.startTime = time.Now()
+
 	go func() {
 		// wait for settle down
-		time.Sleep(10 * time.Second)
+		time.Sleep(healthStartDelay)
 
 		for {
+			// invalidates the discovery cache on CRD changes
+			j.startKubeDiscoveryWatch()
+
 			j.logger.Info("starting janitor run")
 			startTime := time.Now()
 
-			err := j.Run()
+			err := j.RunIsolated()
 			if err != nil {
-				panic(err)
+				if j.cluster == "" {
+					panic(err)
+				}
+
+				// failures of one cluster must not stop the other clusters, the next run retries
+				j.logger.Error("janitor run failed", slog.Any("error", err))
 			}
 
+			// the loop is alive, also if the run failed (liveness)
+			j.lastRunFinished.Store(time.Now().UnixNano())
+
 			j.logger.Info("janitor run finished", slog.Duration("duration", time.Since(startTime)), slog.Time("nextRun", time.Now().Add(interval)))
-			time.Sleep(interval)
+			select {
+			case <-j.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
 		}
 	}()
 
 	return j
 }
 
+// Stop stops the background janitor run and the watches (the current run is canceled)
+func (j *Janitor) Stop() {
+	j.cancel()
+}
+
+// RunIsolated executes one janitor run, in multi-cluster mode panics are recovered and returned as error
+// so one failing cluster doesn't stop the other clusters
+func (j *Janitor) RunIsolated() (err error) {
+	if j.cluster != "" {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("janitor run panicked: %v", r)
+			}
+		}()
+	}
+
+	return j.Run()
+}
+
 // Run executes one janitor rule run
 func (j *Janitor) Run() error {
-	ctx := context.Background()
+	ctx := j.ctx
+
+	// multi-cluster mode connects on the first run, connection failures are retried by the next run
+	if err := j.connect(); err != nil {
+		return err
+	}
+
+	ctx, span := j.startSpan(ctx, "janitor.Run", TracingAttrCluster.String(j.cluster), TracingAttrDryRun.Bool(j.dryRun))
+	defer span.End()
+
+	startTime := time.Now()
+	j.startRunReport()
+	j.metricResourceExpiring.Reset()
+	j.metricResourceExtensions.Reset()
+	err := j.run(ctx)
+	spanRecordError(span, err)
+	j.finishRunReport(ctx, err)
+	j.publishResourceExpiringMetric()
+	j.publishResourceExtensionsMetric()
+
+	j.prometheus.runDuration.Observe(time.Since(startTime).Seconds())
+	if err == nil {
+		j.prometheus.runLastSuccessful.SetToCurrentTime()
+	}
+
+	return err
+}
+
+// run executes all rule types
+func (j *Janitor) run(ctx context.Context) error {
+	if err := j.state.load(ctx, j); err != nil {
+		return err
+	}
+
+	// persist state after every run, also if the run failed
+	defer func() {
+		if saveErr := j.state.save(ctx, j); saveErr != nil {
+			j.logger.Error("unable to save state", slog.Any("error", saveErr))
+		}
+	}()
+
+	if err := j.loadExclusions(ctx); err != nil {
+		return err
+	}
 
 	if j.config.Ttl.Label != "" || j.config.Ttl.Annotation != "" {
 		if err := j.runTtlResources(ctx); err != nil {
@@ -198,5 +425,37 @@ func (j *Janitor) Run() error {
 		j.logger.Debug("skipping rules run, no rules defined")
 	}
 
+	if len(j.config.Namespaces) > 0 {
+		if err := j.runNamespaces(ctx); err != nil {
+			return err
+		}
+	} else {
+		j.logger.Debug("skipping namespaces run, no namespace rules defined")
+	}
+
+	if len(j.config.Orphans) > 0 {
+		if err := j.runOrphans(ctx); err != nil {
+			return err
+		}
+	} else {
+		j.logger.Debug("skipping orphans run, no orphan rules defined")
+	}
+
+	if len(j.config.Volumes) > 0 {
+		if err := j.runVolumes(ctx); err != nil {
+			return err
+		}
+	} else {
+		j.logger.Debug("skipping volumes run, no volume rules defined")
+	}
+
+	if len(j.config.Helm) > 0 {
+		if err := j.runHelm(ctx); err != nil {
+			return err
+		}
+	} else {
+		j.logger.Debug("skipping helm run, no helm rules defined")
+	}
+
 	return nil
 }
@@ -0,0 +1,44 @@
+package kube_janitor
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	kubeFake "k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	// testListKinds are the resources known by the fake dynamic client
+	testListKinds = map[schema.GroupVersionResource]string{
+		{Group: "", Version: "v1", Resource: "configmaps"}:                             "ConfigMapList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}:                        "DeploymentList",
+		{Group: "", Version: "v1", Resource: "pods"}:                                   "PodList",
+		{Group: "", Version: "v1", Resource: "persistentvolumeclaims"}:                 "PersistentVolumeClaimList",
+		{Group: "", Version: "v1", Resource: "persistentvolumes"}:                      "PersistentVolumeList",
+		{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}: "VolumeSnapshotList",
+	}
+)
+
+// newTestJanitor creates a Janitor with fake clients, a separate metrics registry and the memory state store
+func newTestJanitor(t *testing.T, objects ...runtime.Object) *Janitor {
+	t.Helper()
+
+	j := &Janitor{
+		registerer: prometheus.NewRegistry(),
+	}
+	j.init()
+	j.logger = slogger.NewDiscardLogger()
+	j.kubeClient = kubeFake.NewClientset()
+	j.dynClient = dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), testListKinds, objects...)
+
+	j.config = NewConfig()
+	if err := j.config.Validate(); err != nil {
+		t.Fatalf("config validation failed: %v", err)
+	}
+
+	return j
+}
@@ -1,14 +1,78 @@
 package kube_janitor
 
 import (
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// MetricsModeResource exports the expiry of every resource (labels name and ttl)
+	MetricsModeResource = "resource"
+	// MetricsModeAggregated exports the count of resources expiring within the buckets (per rule, namespace and gvk)
+	MetricsModeAggregated = "aggregated"
+	// MetricsModeOff disables the per-resource expiry metrics
+	MetricsModeOff = "off"
+
+	MetricSkipReasonNoTtl          = "noTtl"
+	MetricSkipReasonFilterPath     = "filterPath"
+	MetricSkipReasonName           = "name"
+	MetricSkipReasonExcluded       = "excluded"
+	MetricSkipReasonOverruled      = "overruled"
+	MetricSkipReasonOwner          = "owner"
+	MetricSkipReasonProtected      = "protected"
+	MetricSkipReasonUnparseableTtl = "unparseableTtl"
+
+	MetricParseErrorTtl        = "ttl"
+	MetricParseErrorExtension  = "extension"
+	MetricParseErrorFilterPath = "filterPath"
+)
+
+var (
+	// metricExpiringBuckets are the (cumulative) buckets for the aggregated expiry metric
+	metricExpiringBuckets = []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"1h", 1 * time.Hour},
+		{"24h", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+	}
 )
 
 type (
 	JanitorMetrics struct {
-		deleted *prometheus.CounterVec
-		ttl     *prometheus.GaugeVec
-		rule    *prometheus.GaugeVec
+		deleted      *prometheus.CounterVec
+		action       *prometheus.CounterVec
+		actionFailed *prometheus.CounterVec
+		ttl          *prometheus.GaugeVec
+		rule         *prometheus.GaugeVec
+
+		runDuration       prometheus.Histogram
+		runLastSuccessful prometheus.Gauge
+		ruleDuration      *prometheus.HistogramVec
+
+		resourceScanned *prometheus.CounterVec
+		resourceMatched *prometheus.CounterVec
+		resourceSkipped *prometheus.CounterVec
+
+		ruleOverlap *prometheus.GaugeVec
+		parseErrors *prometheus.CounterVec
+
+		kubeListDuration *prometheus.HistogramVec
+
+		extensions *prometheus.GaugeVec
+
+		resourceExpiring *prometheus.GaugeVec
+
+		resourceTerminating *prometheus.GaugeVec
+
+		namespaceExpiry      *prometheus.GaugeVec
+		namespaceTerminating *prometheus.GaugeVec
 	}
 )
 
@@ -33,7 +97,137 @@ func (j *Janitor) setupMetrics() {
 			"namespace",
 		},
 	)
-	prometheus.MustRegister(j.prometheus.deleted)
+	j.registerer.MustRegister(j.prometheus.deleted)
+
+	j.prometheus.action = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_resource_action_total",
+			Help: "Total count of actions executed on expired Kubernetes resources",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"namespace",
+			"action",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.action)
+
+	j.prometheus.actionFailed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_resource_action_failed_total",
+			Help: "Total count of failed actions (eg. failed deletes) on expired Kubernetes resources",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"namespace",
+			"action",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.actionFailed)
+
+	j.prometheus.runDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "kube_janitor_run_duration_seconds",
+			Help:    "Duration of janitor runs",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.runDuration)
+
+	j.prometheus.runLastSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_last_successful_run_timestamp_seconds",
+			Help: "Unix timestamp of the last successfully finished janitor run",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.runLastSuccessful)
+
+	j.prometheus.ruleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kube_janitor_rule_duration_seconds",
+			Help:    "Duration of rule runs",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{
+			"rule",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.ruleDuration)
+
+	j.prometheus.resourceScanned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_resource_scanned_total",
+			Help: "Total count of Kubernetes resources listed and checked by rules",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.resourceScanned)
+
+	j.prometheus.resourceMatched = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_resource_matched_total",
+			Help: "Total count of Kubernetes resources matched by rules (ttl is evaluated)",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.resourceMatched)
+
+	j.prometheus.resourceSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_resource_skipped_total",
+			Help: "Total count of Kubernetes resources skipped by rules (reason: noTtl, name, filterPath, excluded, overruled, owner, protected, unparseableTtl)",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"reason",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.resourceSkipped)
+
+	j.prometheus.ruleOverlap = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_rule_overlap_count",
+			Help: "Count of Kubernetes resources matched by multiple rules of the last run (rule: winning rule)",
+		},
+		[]string{
+			"rule",
+			"overruledRule",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.ruleOverlap)
+
+	j.prometheus.parseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_janitor_parse_errors_total",
+			Help: "Total count of parse errors (type: ttl, extension, filterPath)",
+		},
+		[]string{
+			"rule",
+			"type",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.parseErrors)
+
+	j.prometheus.kubeListDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kube_janitor_kube_list_duration_seconds",
+			Help:    "Latency of Kubernetes list calls (per page)",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{
+			"groupVersionResource",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.kubeListDuration)
 
 	j.prometheus.ttl = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -42,7 +236,7 @@ func (j *Janitor) setupMetrics() {
 		},
 		ttlLabels,
 	)
-	prometheus.MustRegister(j.prometheus.ttl)
+	j.registerer.MustRegister(j.prometheus.ttl)
 
 	j.prometheus.rule = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -51,5 +245,132 @@ func (j *Janitor) setupMetrics() {
 		},
 		ttlLabels,
 	)
-	prometheus.MustRegister(j.prometheus.rule)
+	j.registerer.MustRegister(j.prometheus.rule)
+
+	j.prometheus.extensions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_resource_expiry_extensions",
+			Help: "Count of expiry extensions (ttl-extend, snooze-until) for Kubernetes resources",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"namespace",
+			"name",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.extensions)
+
+	j.prometheus.resourceExpiring = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_resource_expiring_count",
+			Help: "Count of Kubernetes resources expiring within the time window (aggregated metrics mode)",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"namespace",
+			"within",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.resourceExpiring)
+
+	j.prometheus.resourceTerminating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_resource_terminating_stuck_timestamp_seconds",
+			Help: "Unix timestamp (deletionTimestamp) of Kubernetes resources which are stuck in terminating",
+		},
+		[]string{
+			"rule",
+			"groupVersionKind",
+			"namespace",
+			"name",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.resourceTerminating)
+
+	j.prometheus.namespaceExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_namespace_expiry_timestamp_seconds",
+			Help: "Expiry unix timestamp for namespaces by namespace rule",
+		},
+		[]string{
+			"rule",
+			"namespace",
+			"ttl",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.namespaceExpiry)
+
+	j.prometheus.namespaceTerminating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_janitor_namespace_terminating_timestamp_seconds",
+			Help: "Unix timestamp since when a namespace is terminating (stuck if terminating longer than the threshold)",
+		},
+		[]string{
+			"rule",
+			"namespace",
+			"stuck",
+		},
+	)
+	j.registerer.MustRegister(j.prometheus.namespaceTerminating)
+}
+
+// addResourceExpiryMetric adds the expiry of a not yet expired resource depending on the metrics mode,
+// per-resource metrics are always added for namespaces in the allowlist
+func (j *Janitor) addResourceExpiryMetric(metricList *prometheusCommon.MetricList, rule *ConfigRule, resource unstructured.Unstructured, ttlValue string, expiry time.Time, extensionCount int) {
+	groupVersionKind := resource.GroupVersionKind()
+	gvkLabel := fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind)
+
+	if j.metricsMode == MetricsModeResource || slices.Contains(j.metricsNamespaces, resource.GetNamespace()) {
+		metricList.AddTime(
+			prometheus.Labels{
+				"rule":             rule.Id,
+				"groupVersionKind": gvkLabel,
+				"namespace":        resource.GetNamespace(),
+				"name":             resource.GetName(),
+				"ttl":              ttlValue,
+			},
+			expiry,
+		)
+
+		if extensionCount > 0 {
+			j.metricResourceExtensions.Add(
+				prometheus.Labels{
+					"rule":             rule.Id,
+					"groupVersionKind": gvkLabel,
+					"namespace":        resource.GetNamespace(),
+					"name":             resource.GetName(),
+				},
+				float64(extensionCount),
+			)
+		}
+	}
+
+	if j.metricsMode == MetricsModeAggregated {
+		expiresIn := time.Until(expiry)
+		for _, bucket := range metricExpiringBuckets {
+			if expiresIn <= bucket.duration {
+				j.metricResourceExpiring.Inc(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "namespace": resource.GetNamespace(), "within": bucket.name})
+			}
+		}
+		j.metricResourceExpiring.Inc(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "namespace": resource.GetNamespace(), "within": "+Inf"})
+	}
+}
+
+// publishResourceExtensionsMetric replaces the extension metric with the extensions of the current run,
+// series of deleted or not extended resources are removed
+func (j *Janitor) publishResourceExtensionsMetric() {
+	j.prometheus.extensions.Reset()
+	j.metricResourceExtensions.GaugeSet(j.prometheus.extensions)
+}
+
+// publishResourceExpiringMetric replaces the aggregated expiry metric with the counts of the current run
+func (j *Janitor) publishResourceExpiringMetric() {
+	if j.metricsMode != MetricsModeAggregated {
+		return
+	}
+
+	j.prometheus.resourceExpiring.Reset()
+	j.metricResourceExpiring.GaugeSet(j.prometheus.resourceExpiring)
 }
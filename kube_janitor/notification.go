@@ -0,0 +1,82 @@
+package kube_janitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	NotificationTimeout = 10 * time.Second
+)
+
+type (
+	// NotificationMessage is the JSON payload which is sent to the notification webhook
+	NotificationMessage struct {
+		Rule             string    `json:"rule"`
+		Reason           string    `json:"reason"`
+		Message          string    `json:"message"`
+		GroupVersionKind string    `json:"groupVersionKind"`
+		Namespace        string    `json:"namespace,omitempty"`
+		Name             string    `json:"name"`
+		Uid              string    `json:"uid"`
+		ExpirationDate   time.Time `json:"expirationDate"`
+		ActionDate       time.Time `json:"actionDate,omitzero"`
+	}
+)
+
+// newNotificationMessage creates a notification message for a resource
+func newNotificationMessage(rule *ConfigRule, resource unstructured.Unstructured, reason, message string, expirationDate time.Time) NotificationMessage {
+	groupVersionKind := resource.GroupVersionKind()
+	return NotificationMessage{
+		Rule:             rule.Id,
+		Reason:           reason,
+		Message:          message,
+		GroupVersionKind: fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+		Namespace:        resource.GetNamespace(),
+		Name:             resource.GetName(),
+		Uid:              string(resource.GetUID()),
+		ExpirationDate:   expirationDate,
+	}
+}
+
+// sendNotification sends the notification message as JSON to the configured webhook
+func (j *Janitor) sendNotification(ctx context.Context, config *ConfigNotification, notification NotificationMessage) error {
+	if config == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, NotificationTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf(`notification webhook returned status %s`, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// OwnerModeSkip skips resources if their controller owner is also matched by the rule (default)
+	OwnerModeSkip = "skip"
+
+	// OwnerModeOwner processes the top-level controller owner instead of the matched resource
+	OwnerModeOwner = "owner"
+
+	// OwnerModeIgnore processes every matched resource independently
+	OwnerModeIgnore = "ignore"
+
+	// OwnerOrderOwnersFirst processes owners before their dependents (default)
+	OwnerOrderOwnersFirst = "ownersFirst"
+
+	// OwnerOrderDependentsFirst processes dependents before their owners
+	OwnerOrderDependentsFirst = "dependentsFirst"
+
+	// OwnerMaxDepth limits the walk up the owner chain
+	OwnerMaxDepth = 10
+)
+
+type (
+	// ruleCandidate is a resource matched by a rule, waiting for the expiry check
+	ruleCandidate struct {
+		logger         *slogger.Logger
+		resourceConfig *ConfigResource
+		resource       unstructured.Unstructured
+		ttl            string
+	}
+
+	// ruleCandidateOwner is the resolved top-level owner of a resource
+	ruleCandidateOwner struct {
+		resourceConfig *ConfigResource
+		resource       unstructured.Unstructured
+	}
+)
+
+// resolveRuleCandidateOwners applies the owner handling of the rule and orders the candidates by the owner graph
+func (j *Janitor) resolveRuleCandidateOwners(ctx context.Context, logger *slogger.Logger, rule *ConfigRule, candidates []*ruleCandidate) []*ruleCandidate {
+	switch rule.Owners.Mode {
+	case OwnerModeIgnore:
+		// process every resource
+	case OwnerModeOwner:
+		candidates = j.resolveRuleCandidatesToTopLevelOwner(ctx, logger, rule, candidates)
+	default:
+		candidates = skipRuleCandidatesWithManagedOwner(candidates)
+	}
+
+	sortRuleCandidatesByOwnerGraph(candidates, rule.Owners.Order)
+
+	return candidates
+}
+
+// skipRuleCandidatesWithManagedOwner removes all candidates which controller owner is also a candidate,
+// the controller would recreate them anyway and they are removed by the garbage collector with the owner
+func skipRuleCandidatesWithManagedOwner(candidates []*ruleCandidate) []*ruleCandidate {
+	managed := map[types.UID]bool{}
+	for _, candidate := range candidates {
+		managed[candidate.resource.GetUID()] = true
+	}
+
+	ret := make([]*ruleCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if ownerRef := controllerOwnerReference(candidate.resource); ownerRef != nil && managed[ownerRef.UID] {
+			candidate.logger.Debug(
+				"resource skipped, controller owner is also managed by rule",
+				slog.String("namespace", candidate.resource.GetNamespace()),
+				slog.String("name", candidate.resource.GetName()),
+				slog.String("owner", ownerRef.Kind+"/"+ownerRef.Name),
+			)
+			continue
+		}
+
+		ret = append(ret, candidate)
+	}
+
+	return ret
+}
+
+// resolveRuleCandidatesToTopLevelOwner replaces the candidates with their top-level controller owner (deduplicated),
+// the owner inherits the ttl from the matched resource. Owners which are not allowed for the rule (see ruleAllowsOwner)
+// are never processed, the candidate is skipped as the owner would recreate it anyway.
+func (j *Janitor) resolveRuleCandidatesToTopLevelOwner(ctx context.Context, logger *slogger.Logger, rule *ConfigRule, candidates []*ruleCandidate) []*ruleCandidate {
+	ret := make([]*ruleCandidate, 0, len(candidates))
+	seen := map[types.UID]bool{}
+	ownerCache := map[types.UID]*ruleCandidateOwner{}
+
+	// resources of the rule (wildcards resolved), owners have to be one of them
+	resourceList, resourceListErr := j.kubeLookupGvkList(ctx, rule.Resources, rule.isNamespaced())
+
+	// top-level resources first, so they keep their own ttl
+	for _, candidate := range candidates {
+		if controllerOwnerReference(candidate.resource) == nil {
+			seen[candidate.resource.GetUID()] = true
+			ret = append(ret, candidate)
+		}
+	}
+
+	for _, candidate := range candidates {
+		ownerRef := controllerOwnerReference(candidate.resource)
+		if ownerRef == nil {
+			continue
+		}
+
+		owner, err := j.kubeFetchTopLevelOwner(ctx, candidate.resource, ownerCache)
+		if err != nil {
+			candidate.logger.Warn(
+				"unable to fetch owner of resource, processing resource itself",
+				slog.String("namespace", candidate.resource.GetNamespace()),
+				slog.String("name", candidate.resource.GetName()),
+				slog.Any("error", err),
+			)
+			owner = nil
+		}
+
+		if owner == nil {
+			// owner is gone or unknown, use resource itself
+			if !seen[candidate.resource.GetUID()] {
+				seen[candidate.resource.GetUID()] = true
+				ret = append(ret, candidate)
+			}
+			continue
+		}
+
+		allowed, err := false, resourceListErr
+		if err == nil {
+			allowed, err = j.ruleAllowsOwner(rule, resourceList, owner)
+		}
+		if err != nil || !allowed {
+			candidate.logger.Debug(
+				"resource skipped, top-level owner is not allowed for rule",
+				slog.String("namespace", candidate.resource.GetNamespace()),
+				slog.String("name", candidate.resource.GetName()),
+				slog.String("owner", owner.resource.GetKind()+"/"+owner.resource.GetName()),
+				slog.Any("error", err),
+			)
+			groupVersionKind := candidate.resource.GroupVersionKind()
+			j.prometheus.resourceSkipped.With(prometheus.Labels{
+				"rule":             rule.Id,
+				"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+				"reason":           MetricSkipReasonOwner,
+			}).Inc()
+			continue
+		}
+
+		if seen[owner.resource.GetUID()] {
+			continue
+		}
+		seen[owner.resource.GetUID()] = true
+
+		candidate.logger.Debug(
+			"resource resolved to top-level owner",
+			slog.String("namespace", candidate.resource.GetNamespace()),
+			slog.String("name", candidate.resource.GetName()),
+			slog.String("owner", owner.resource.GetKind()+"/"+owner.resource.GetName()),
+		)
+
+		ret = append(ret, &ruleCandidate{
+			logger:         logger.With(slog.String("groupVersionKind", owner.resourceConfig.String())),
+			resourceConfig: owner.resourceConfig,
+			resource:       owner.resource,
+			ttl:            candidate.ttl,
+		})
+	}
+
+	return ret
+}
+
+// ruleAllowsOwner checks if the owner can be processed by the rule instead of the matched resource: the owner has to match
+// one of the resources of the rule (group, kind, names, selector and filterPath), has to be namespaced if the rule is
+// namespaced and must not be excluded. Otherwise a rule could escalate to other kinds (eg. mirror pods are owned by their Node).
+func (j *Janitor) ruleAllowsOwner(rule *ConfigRule, resourceList ConfigResourceList, owner *ruleCandidateOwner) (bool, error) {
+	if rule.isNamespaced() && owner.resource.GetNamespace() == KubeNoNamespace {
+		return false, nil
+	}
+
+	if j.exclusions.find(rule.Id, &owner.resource) != nil {
+		return false, nil
+	}
+
+	for _, resourceType := range resourceList {
+		if !strings.EqualFold(resourceType.Group, owner.resourceConfig.Group) || !strings.EqualFold(resourceType.Kind, owner.resourceConfig.Kind) {
+			continue
+		}
+
+		if !matchesIncludeExclude(owner.resource.GetName(), resourceType.Names, resourceType.ExcludeNames) {
+			continue
+		}
+
+		if !resourceType.Selector.IsEmpty() {
+			selector, err := metav1.LabelSelectorAsSelector(&resourceType.Selector.LabelSelector)
+			if err != nil {
+				return false, err
+			} else if !selector.Matches(labels.Set(owner.resource.GetLabels())) {
+				continue
+			}
+		}
+
+		if !resourceType.FilterPath.IsEmpty() {
+			skipped, err := j.checkResourceIsSkippedFromJmesPath(owner.resource, resourceType.FilterPath)
+			if err != nil {
+				return false, err
+			} else if skipped {
+				continue
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// kubeFetchTopLevelOwner walks up the controller owner chain, returns nil if the resource has no (existing) owner
+func (j *Janitor) kubeFetchTopLevelOwner(ctx context.Context, resource unstructured.Unstructured, ownerCache map[types.UID]*ruleCandidateOwner) (*ruleCandidateOwner, error) {
+	var ret *ruleCandidateOwner
+
+	current := resource
+	for depth := 0; depth < OwnerMaxDepth; depth++ {
+		ownerRef := controllerOwnerReference(current)
+		if ownerRef == nil {
+			break
+		}
+
+		if owner, exists := ownerCache[ownerRef.UID]; exists {
+			if owner == nil {
+				break
+			}
+			ret = owner
+			current = owner.resource
+			continue
+		}
+
+		ownerConfig, namespaced, err := j.kubeLookupResourceByKind(ctx, ownerRef.APIVersion, ownerRef.Kind)
+		if err != nil {
+			return nil, err
+		} else if ownerConfig == nil {
+			// kind not available for the janitor (eg. not deletable)
+			ownerCache[ownerRef.UID] = nil
+			break
+		}
+
+		namespace := KubeNoNamespace
+		if namespaced {
+			namespace = current.GetNamespace()
+		}
+
+		owner, err := j.dynClient.Resource(ownerConfig.AsGVR()).Namespace(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && owner.GetUID() != ownerRef.UID) {
+			// owner is already deleted (or recreated), the garbage collector takes care of the dependent
+			ownerCache[ownerRef.UID] = nil
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		ret = &ruleCandidateOwner{
+			resourceConfig: ownerConfig,
+			resource:       *owner,
+		}
+		ownerCache[ownerRef.UID] = ret
+		current = *owner
+	}
+
+	return ret, nil
+}
+
+// sortRuleCandidatesByOwnerGraph orders the candidates by their depth in the owner graph (between the candidates)
+func sortRuleCandidatesByOwnerGraph(candidates []*ruleCandidate, order string) {
+	byUID := map[types.UID]*ruleCandidate{}
+	for _, candidate := range candidates {
+		byUID[candidate.resource.GetUID()] = candidate
+	}
+
+	depthCache := map[types.UID]int{}
+	var depth func(candidate *ruleCandidate, visited map[types.UID]bool) int
+	depth = func(candidate *ruleCandidate, visited map[types.UID]bool) int {
+		uid := candidate.resource.GetUID()
+		if val, exists := depthCache[uid]; exists {
+			return val
+		}
+
+		// cycle protection
+		if visited[uid] {
+			return 0
+		}
+		visited[uid] = true
+
+		ret := 0
+		for _, ownerRef := range candidate.resource.GetOwnerReferences() {
+			if owner, exists := byUID[ownerRef.UID]; exists {
+				ret = max(ret, depth(owner, visited)+1)
+			}
+		}
+
+		depthCache[uid] = ret
+		return ret
+	}
+
+	for _, candidate := range candidates {
+		depth(candidate, map[types.UID]bool{})
+	}
+
+	slices.SortStableFunc(candidates, func(a, b *ruleCandidate) int {
+		depthA := depthCache[a.resource.GetUID()]
+		depthB := depthCache[b.resource.GetUID()]
+
+		if order == OwnerOrderDependentsFirst {
+			return depthB - depthA
+		}
+		return depthA - depthB
+	})
+}
+
+// controllerOwnerReference returns the controller ownerReference of the resource
+func controllerOwnerReference(resource unstructured.Unstructured) *metav1.OwnerReference {
+	for _, ownerRef := range resource.GetOwnerReferences() {
+		if ownerRef.Controller != nil && *ownerRef.Controller {
+			return &ownerRef
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package kube_janitor
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testOwnerObject creates a resource with an optional controller owner
+func testOwnerObject(apiVersion, kind, namespace, name string, uid types.UID, owner *unstructured.Unstructured) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetUID(uid)
+
+	if owner != nil {
+		controller := true
+		obj.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: owner.GetAPIVersion(),
+			Kind:       owner.GetKind(),
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+			Controller: &controller,
+		}})
+	}
+
+	return obj
+}
+
+// testOwnerCandidateNames returns the kind/name of the candidates
+func testOwnerCandidateNames(candidates []*ruleCandidate) []string {
+	ret := []string{}
+	for _, candidate := range candidates {
+		ret = append(ret, candidate.resource.GetKind()+"/"+candidate.resource.GetName())
+	}
+	return ret
+}
+
+func TestResolveRuleCandidatesToTopLevelOwner(t *testing.T) {
+	node := testOwnerObject("v1", "Node", "", "worker-1", "n1", nil)
+	deployment := testOwnerObject("apps/v1", "Deployment", "test", "app", "d1", nil)
+	replicaSet := testOwnerObject("apps/v1", "ReplicaSet", "test", "app-7d9f", "r1", deployment)
+	pod := testOwnerObject("v1", "Pod", "test", "app-7d9f-x2k4", "p1", replicaSet)
+	mirrorPod := testOwnerObject("v1", "Pod", "test", "kube-proxy-worker-1", "p2", node)
+	standalonePod := testOwnerObject("v1", "Pod", "test", "debug", "p3", nil)
+
+	podResource := &ConfigResource{Group: "", Version: "v1", Kind: "pods"}
+	deploymentResource := &ConfigResource{Group: "apps", Version: "v1", Kind: "deployments"}
+	nodeResource := &ConfigResource{Group: "", Version: "v1", Kind: "nodes"}
+	namespaces := ConfigPatternList{{Pattern: "test"}}
+
+	testCases := []struct {
+		name           string
+		resources      ConfigResourceList
+		namespaces     ConfigPatternList
+		exclusions     map[types.UID][]*ConfigExcludeRule
+		wantCandidates []string
+	}{
+		{
+			name:           "resolved to owner of the rule resources, mirror pod is not escalated to node",
+			resources:      ConfigResourceList{podResource, deploymentResource},
+			namespaces:     namespaces,
+			wantCandidates: []string{"Pod/debug", "Deployment/app"},
+		},
+		{
+			name:           "owner kind is not a rule resource",
+			resources:      ConfigResourceList{podResource},
+			wantCandidates: []string{"Pod/debug"},
+		},
+		{
+			name:           "cluster owner is allowed for cluster rule with the owner resource",
+			resources:      ConfigResourceList{podResource, nodeResource},
+			wantCandidates: []string{"Pod/debug", "Node/worker-1"},
+		},
+		{
+			name:           "cluster owner is not allowed for namespaced rule",
+			resources:      ConfigResourceList{podResource, nodeResource},
+			namespaces:     namespaces,
+			wantCandidates: []string{"Pod/debug"},
+		},
+		{
+			name:           "owner names of the rule resource",
+			resources:      ConfigResourceList{podResource, {Group: "apps", Version: "v1", Kind: "deployments", Names: ConfigPatternList{{Pattern: "other-*"}}}},
+			wantCandidates: []string{"Pod/debug"},
+		},
+		{
+			name:           "excluded owner",
+			resources:      ConfigResourceList{podResource, deploymentResource},
+			exclusions:     map[types.UID][]*ConfigExcludeRule{"d1": {{Id: "KeepDeployments"}}},
+			wantCandidates: []string{"Pod/debug"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			j := newTestJanitor(t, node, deployment, replicaSet, pod, mirrorPod, standalonePod)
+			j.cache.Set(kubeDiscoveryCacheKey, &kubeDiscovery{gvkList: testOwnerDiscovery}, 0)
+			if testCase.exclusions != nil {
+				j.exclusions = &exclusionSet{resources: testCase.exclusions}
+			}
+
+			rule := &ConfigRule{
+				Id:         "CleanupPods",
+				Resources:  testCase.resources,
+				Namespaces: testCase.namespaces,
+				Owners:     ConfigRuleOwners{Mode: OwnerModeOwner},
+			}
+
+			candidates := []*ruleCandidate{}
+			for _, obj := range []*unstructured.Unstructured{pod, mirrorPod, standalonePod} {
+				candidates = append(candidates, &ruleCandidate{logger: j.logger, resourceConfig: podResource, resource: *obj, ttl: "1d"})
+			}
+
+			result := testOwnerCandidateNames(j.resolveRuleCandidateOwners(ctx, j.logger, rule, candidates))
+			if !slices.Equal(result, testCase.wantCandidates) {
+				t.Fatalf("candidates: got %v, want %v", result, testCase.wantCandidates)
+			}
+		})
+	}
+}
+
+func TestSortRuleCandidatesByOwnerGraph(t *testing.T) {
+	deployment := testOwnerObject("apps/v1", "Deployment", "test", "app", "d1", nil)
+	replicaSet := testOwnerObject("apps/v1", "ReplicaSet", "test", "app-7d9f", "r1", deployment)
+	pod := testOwnerObject("v1", "Pod", "test", "app-7d9f-x2k4", "p1", replicaSet)
+	standalonePod := testOwnerObject("v1", "Pod", "test", "debug", "p3", nil)
+
+	testCases := []struct {
+		name           string
+		order          string
+		wantCandidates []string
+	}{
+		{
+			name:           "owners first (default)",
+			order:          "",
+			wantCandidates: []string{"Deployment/app", "Pod/debug", "ReplicaSet/app-7d9f", "Pod/app-7d9f-x2k4"},
+		},
+		{
+			name:           "dependents first",
+			order:          OwnerOrderDependentsFirst,
+			wantCandidates: []string{"Pod/app-7d9f-x2k4", "ReplicaSet/app-7d9f", "Deployment/app", "Pod/debug"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			candidates := []*ruleCandidate{}
+			for _, obj := range []*unstructured.Unstructured{pod, deployment, replicaSet, standalonePod} {
+				candidates = append(candidates, &ruleCandidate{resource: *obj})
+			}
+
+			sortRuleCandidatesByOwnerGraph(candidates, testCase.order)
+
+			if result := testOwnerCandidateNames(candidates); !slices.Equal(result, testCase.wantCandidates) {
+				t.Fatalf("order: got %v, want %v", result, testCase.wantCandidates)
+			}
+		})
+	}
+}
+
+// testOwnerDiscovery are the discovered resources for the owner tests
+var testOwnerDiscovery = KubeServerGroupVersionKindList{
+	{GroupVersionKind: metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "pods"}, Namespaced: true, ObjectKind: "Pod", Preferred: true},
+	{GroupVersionKind: metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "nodes"}, Namespaced: false, ObjectKind: "Node", Preferred: true},
+	{GroupVersionKind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "deployments"}, Namespaced: true, ObjectKind: "Deployment", Preferred: true},
+	{GroupVersionKind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "replicasets"}, Namespaced: true, ObjectKind: "ReplicaSet", Preferred: true},
+}
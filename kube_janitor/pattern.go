@@ -0,0 +1,88 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+type (
+	// ConfigPatternList is a list of name patterns, matches if any pattern matches
+	ConfigPatternList []*ConfigPattern
+
+	// ConfigPattern is a glob pattern (eg. pr-*) or a regular expression enclosed in slashes (eg. /^pr-[0-9]+$/)
+	ConfigPattern struct {
+		Pattern  string
+		compiled *regexp.Regexp
+	}
+)
+
+// UnmarshallConfigPattern parses the pattern from a string and compiles it at the same time
+func UnmarshallConfigPattern(ctx context.Context, pattern *ConfigPattern, data []byte) error {
+	var valString string
+
+	err := yaml.UnmarshalContext(ctx, data, &valString, yaml.Strict())
+	if err != nil {
+		return fmt.Errorf(`failed to parse pattern as string: %w`, err)
+	}
+
+	valString = strings.TrimSpace(valString)
+	if valString == "" {
+		return fmt.Errorf(`pattern must not be empty`)
+	}
+
+	if len(valString) >= 2 && strings.HasPrefix(valString, "/") && strings.HasSuffix(valString, "/") {
+		// regular expression
+		compiled, err := regexp.Compile(valString[1 : len(valString)-1])
+		if err != nil {
+			return fmt.Errorf(`failed to compile regular expression "%s": %w`, valString, err)
+		}
+		pattern.compiled = compiled
+	} else if _, err := path.Match(valString, ""); err != nil {
+		// glob
+		return fmt.Errorf(`invalid glob pattern "%s": %w`, valString, err)
+	}
+
+	pattern.Pattern = valString
+
+	return nil
+}
+
+// Matches checks if the value matches the pattern
+func (p *ConfigPattern) Matches(value string) bool {
+	if p.compiled != nil {
+		return p.compiled.MatchString(value)
+	}
+
+	matched, _ := path.Match(p.Pattern, value)
+	return matched
+}
+
+// IsEmpty returns true if no pattern is defined
+func (l ConfigPatternList) IsEmpty() bool {
+	return len(l) == 0
+}
+
+// Matches checks if the value matches any pattern of the list
+func (l ConfigPatternList) Matches(value string) bool {
+	for _, pattern := range l {
+		if pattern.Matches(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesIncludeExclude checks if the value is included (no include list or matching) and not excluded
+func matchesIncludeExclude(value string, include, exclude ConfigPatternList) bool {
+	if !include.IsEmpty() && !include.Matches(value) {
+		return false
+	}
+
+	return !exclude.Matches(value)
+}
@@ -0,0 +1,164 @@
+package kube_janitor
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"fortio.org/duration"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// OverlapPolicyAll evaluates every rule independently (default)
+	OverlapPolicyAll = "all"
+
+	// OverlapPolicyFirstMatch processes the resource only by the first matching rule (by priority, then config order)
+	OverlapPolicyFirstMatch = "firstMatch"
+
+	// OverlapPolicyShortestTtl processes the resource only by the matching rule with the shortest ttl
+	OverlapPolicyShortestTtl = "shortestTtl"
+
+	// OverlapPolicyLongestTtl processes the resource only by the matching rule with the longest ttl
+	OverlapPolicyLongestTtl = "longestTtl"
+)
+
+type (
+	// ruleMatch contains the matched resources of one rule (by namespace)
+	ruleMatch struct {
+		namespaces []string
+		candidates map[string][]*ruleCandidate
+		duration   time.Duration
+	}
+
+	// ruleOverlapMatch is one matching rule of a resource
+	ruleOverlapMatch struct {
+		rule      *ConfigRule
+		candidate *ruleCandidate
+	}
+)
+
+// sortRulesByPriority returns the rules ordered by priority (highest first), rules with the same priority keep the config order
+func sortRulesByPriority(rules []*ConfigRule) []*ConfigRule {
+	ret := slices.Clone(rules)
+	slices.SortStableFunc(ret, func(a, b *ConfigRule) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+	return ret
+}
+
+// resolveRuleOverlaps removes the resources matched by multiple rules from all rules except the winning rule
+// (see OverlapPolicy*), the rules have to be ordered by priority and matches have to be in the same order as the rules
+func (j *Janitor) resolveRuleOverlaps(rules []*ConfigRule, matches []*ruleMatch) {
+	j.prometheus.ruleOverlap.Reset()
+	j.ruleOverruled = map[string][]string{}
+
+	overlaps := map[types.UID][]*ruleOverlapMatch{}
+	resourceOrder := []types.UID{}
+	for i, rule := range rules {
+		for _, candidates := range matches[i].candidates {
+			for _, candidate := range candidates {
+				uid := candidate.resource.GetUID()
+				// one rule can match the same resource multiple times (eg. wildcards)
+				if slices.ContainsFunc(overlaps[uid], func(m *ruleOverlapMatch) bool { return m.rule == rule }) {
+					continue
+				}
+
+				if _, exists := overlaps[uid]; !exists {
+					resourceOrder = append(resourceOrder, uid)
+				}
+				overlaps[uid] = append(overlaps[uid], &ruleOverlapMatch{rule: rule, candidate: candidate})
+			}
+		}
+	}
+
+	overruled := map[*ruleCandidate]bool{}
+	for _, uid := range resourceOrder {
+		ruleMatches := overlaps[uid]
+		if len(ruleMatches) <= 1 {
+			continue
+		}
+
+		winner := j.findWinningRule(ruleMatches)
+		overruledRules := []string{}
+		for _, m := range ruleMatches {
+			if m == winner {
+				continue
+			}
+
+			overruled[m.candidate] = true
+			overruledRules = append(overruledRules, m.rule.Id)
+
+			resource := m.candidate.resource
+			groupVersionKind := resource.GroupVersionKind()
+			j.prometheus.resourceSkipped.With(
+				prometheus.Labels{
+					"rule":             m.rule.Id,
+					"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+					"reason":           MetricSkipReasonOverruled,
+				},
+			).Inc()
+			j.prometheus.ruleOverlap.With(prometheus.Labels{"rule": winner.rule.Id, "overruledRule": m.rule.Id}).Inc()
+		}
+
+		j.ruleOverruled[ruleOverruledKey(winner.rule, uid)] = overruledRules
+		winner.candidate.logger = winner.candidate.logger.With(slog.Any("overruledRules", overruledRules))
+		winner.candidate.logger.Debug(
+			"resource is matched by multiple rules",
+			slog.String("namespace", winner.candidate.resource.GetNamespace()),
+			slog.String("name", winner.candidate.resource.GetName()),
+			slog.String("winningRule", winner.rule.Id),
+			slog.String("overlapPolicy", j.config.OverlapPolicy),
+		)
+	}
+
+	for _, match := range matches {
+		for namespace, candidates := range match.candidates {
+			match.candidates[namespace] = slices.DeleteFunc(candidates, func(candidate *ruleCandidate) bool {
+				return overruled[candidate]
+			})
+		}
+	}
+}
+
+// findWinningRule returns the rule match which processes the resource (matches are ordered by priority),
+// ttls which are not durations (eg. dates) are not compared
+func (j *Janitor) findWinningRule(ruleMatches []*ruleOverlapMatch) *ruleOverlapMatch {
+	winner := ruleMatches[0]
+	if j.config.OverlapPolicy == OverlapPolicyFirstMatch {
+		return winner
+	}
+
+	winnerTtl, winnerOk := ruleOverlapTtl(winner)
+	for _, m := range ruleMatches[1:] {
+		ttl, ok := ruleOverlapTtl(m)
+		if !ok {
+			continue
+		}
+
+		if !winnerOk ||
+			(j.config.OverlapPolicy == OverlapPolicyShortestTtl && ttl < winnerTtl) ||
+			(j.config.OverlapPolicy == OverlapPolicyLongestTtl && ttl > winnerTtl) {
+			winner, winnerTtl, winnerOk = m, ttl, true
+		}
+	}
+
+	return winner
+}
+
+// ruleOverlapTtl parses the ttl of the rule match as duration
+func ruleOverlapTtl(m *ruleOverlapMatch) (time.Duration, bool) {
+	ttl, err := duration.Parse(m.candidate.ttl)
+	if err != nil {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// ruleOverruledKey returns the key of the overruled rules of a resource processed by the winning rule
+func ruleOverruledKey(rule *ConfigRule, uid types.UID) string {
+	return rule.Id + "." + string(uid)
+}
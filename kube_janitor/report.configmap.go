@@ -0,0 +1,120 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ReportConfigMapDataKey = "reports.json"
+
+	// ReportConfigMapMaxSize is the max size of the reports inside the ConfigMap (Kubernetes limits objects to 1MiB)
+	ReportConfigMapMaxSize = 900 * 1024
+)
+
+type (
+	// ReportBackendConfigMapStore persists the reports as JSON list inside a ConfigMap (limited to 1MiB by Kubernetes,
+	// the oldest reports are removed if the reports exceed ReportConfigMapMaxSize)
+	ReportBackendConfigMapStore struct {
+		namespace string
+		name      string
+	}
+)
+
+// NewReportBackendConfigMap creates the ConfigMap report backend, the ConfigMap is created on the first save
+func NewReportBackendConfigMap(namespace, name string) *ReportBackendConfigMapStore {
+	return &ReportBackendConfigMapStore{
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (b *ReportBackendConfigMapStore) Name() string {
+	return ReportBackendConfigMap
+}
+
+// Save appends the report to the list inside the ConfigMap and only keeps the retained reports
+func (b *ReportBackendConfigMapStore) Save(ctx context.Context, j *Janitor, report *RunReport, retention ReportRetention) error {
+	reports := []json.RawMessage{}
+
+	configMap, err := j.kubeClient.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = nil
+	} else if err != nil {
+		return err
+	} else if data, exists := configMap.Data[ReportConfigMapDataKey]; exists && data != "" {
+		if err := json.Unmarshal([]byte(data), &reports); err != nil {
+			// broken content, start again
+			j.logger.Warn("unable to parse reports from ConfigMap, replacing content")
+			reports = []json.RawMessage{}
+		}
+	}
+
+	reportData, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	reports = applyReportRetention(retention, append(reports, reportData))
+
+	data, err := json.Marshal(reports)
+	if err != nil {
+		return err
+	}
+
+	// the oldest reports are removed if the ConfigMap would exceed the size limit (the current report is always kept)
+	removed := 0
+	for len(data) > ReportConfigMapMaxSize && len(reports) > 1 {
+		reports = reports[1:]
+		removed++
+
+		data, err = json.Marshal(reports)
+		if err != nil {
+			return err
+		}
+	}
+
+	if removed > 0 {
+		j.logger.Warn(
+			"reports exceed the max ConfigMap size, removed oldest reports",
+			slog.Int("removed", removed),
+			slog.Int("reports", len(reports)),
+		)
+	}
+
+	if configMap == nil {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: b.namespace,
+				Name:      b.name,
+				Labels: map[string]string{
+					KubeLabelManagedBy: KubeLabelManagedByValue,
+				},
+			},
+			Data: map[string]string{
+				ReportConfigMapDataKey: string(data),
+			},
+		}
+		_, err = j.kubeClient.CoreV1().ConfigMaps(b.namespace).Create(ctx, configMap, metav1.CreateOptions{FieldManager: KubeFieldManager})
+		return err
+	}
+
+	// ConfigMaps created before the label was added are labeled too (excluded from orphan rules)
+	if configMap.Labels == nil {
+		configMap.Labels = map[string]string{}
+	}
+	configMap.Labels[KubeLabelManagedBy] = KubeLabelManagedByValue
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[ReportConfigMapDataKey] = string(data)
+
+	_, err = j.kubeClient.CoreV1().ConfigMaps(b.namespace).Update(ctx, configMap, metav1.UpdateOptions{FieldManager: KubeFieldManager})
+	return err
+}
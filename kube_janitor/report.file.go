@@ -0,0 +1,82 @@
+package kube_janitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+type (
+	// ReportBackendFileStore persists the reports as JSON lines (one report per line) in a local file
+	ReportBackendFileStore struct {
+		path string
+	}
+)
+
+// NewReportBackendFile creates the JSON-lines file report backend
+func NewReportBackendFile(path string) *ReportBackendFileStore {
+	return &ReportBackendFileStore{path: path}
+}
+
+func (b *ReportBackendFileStore) Name() string {
+	return ReportBackendFile
+}
+
+// Save appends the report and rewrites the file with the retained reports (atomic rename)
+func (b *ReportBackendFileStore) Save(ctx context.Context, j *Janitor, report *RunReport, retention ReportRetention) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	var lines [][]byte
+
+	/* #nosec */
+	if file, err := os.Open(b.path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+				lines = append(lines, bytes.Clone(scanner.Bytes()))
+			}
+		}
+		scanErr := scanner.Err()
+		if err := file.Close(); err != nil {
+			return err
+		}
+		if scanErr != nil {
+			return scanErr
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines = applyReportRetention(retention, append(lines, line))
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // nolint:errcheck
+
+	writer := bufio.NewWriter(tmpFile)
+	for _, line := range lines {
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			tmpFile.Close() // nolint:errcheck
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close() // nolint:errcheck
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), b.path)
+}
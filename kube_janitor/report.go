@@ -0,0 +1,218 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	ReportBackendFile      = "file"
+	ReportBackendConfigMap = "configmap"
+
+	// ReportDefaultMaxAge keeps the reports of the last week
+	ReportDefaultMaxAge = 7 * 24 * time.Hour
+)
+
+type (
+	// RunReportBackend persists the reports of the last runs
+	RunReportBackend interface {
+		// Name returns the name of the backend
+		Name() string
+
+		// Save appends the report and removes the reports which are not retained anymore
+		Save(ctx context.Context, j *Janitor, report *RunReport, retention ReportRetention) error
+	}
+
+	// ReportRetention defines which reports are kept by the report backend, both limits are applied
+	ReportRetention struct {
+		// MaxAge removes reports which are older (by start time, 0 = no limit)
+		MaxAge time.Duration
+
+		// Keep limits the count of reports (0 = no limit)
+		Keep int
+	}
+
+	// RunReport is the structured report of one janitor run
+	RunReport struct {
+		StartTime time.Time `json:"startTime"`
+		EndTime   time.Time `json:"endTime"`
+		Duration  string    `json:"duration"`
+		Cluster   string    `json:"cluster,omitempty"`
+		DryRun    bool      `json:"dryRun"`
+		Error     string    `json:"error,omitempty"`
+
+		Rules     []*RunReportRule     `json:"rules"`
+		Resources []*RunReportResource `json:"resources"`
+
+		lock sync.Mutex
+	}
+
+	// RunReportRule contains the stats of one rule
+	RunReportRule struct {
+		Id       string `json:"id"`
+		Checked  int    `json:"checked"`
+		Expired  int    `json:"expired"`
+		Executed int    `json:"executed"`
+		Failed   int    `json:"failed"`
+	}
+
+	// RunReportResource is one processed (or in dry-run: would be processed) resource
+	RunReportResource struct {
+		Rule             string    `json:"rule"`
+		GroupVersionKind string    `json:"groupVersionKind"`
+		Namespace        string    `json:"namespace,omitempty"`
+		Name             string    `json:"name"`
+		Action           string    `json:"action"`
+		DryRun           bool      `json:"dryRun"`
+		Ttl              string    `json:"ttl"`
+		Expiry           time.Time `json:"expiry"`
+		Reason           string    `json:"reason"`
+		Error            string    `json:"error,omitempty"`
+	}
+)
+
+// newRunReport creates the report for a new run
+func newRunReport(dryRun bool) *RunReport {
+	return &RunReport{
+		StartTime: time.Now(),
+		DryRun:    dryRun,
+		Rules:     []*RunReportRule{},
+		Resources: []*RunReportResource{},
+	}
+}
+
+// rule returns the stats of the rule (created on first use), needs to be called with lock
+func (r *RunReport) rule(id string) *RunReportRule {
+	for _, rule := range r.Rules {
+		if rule.Id == id {
+			return rule
+		}
+	}
+
+	rule := &RunReportRule{Id: id}
+	r.Rules = append(r.Rules, rule)
+	return rule
+}
+
+// addChecked counts a checked resource for the rule
+func (r *RunReport) addChecked(ruleId string, expired bool) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	rule := r.rule(ruleId)
+	rule.Checked++
+	if expired {
+		rule.Expired++
+	}
+}
+
+// addResource adds a processed resource (executed, would be executed in dry-run or failed) to the report
+func (r *RunReport) addResource(ruleId string, resource unstructured.Unstructured, action, ttl string, expiry time.Time, reason string, dryRun bool, err error) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	groupVersionKind := resource.GroupVersionKind()
+	entry := &RunReportResource{
+		Rule:             ruleId,
+		GroupVersionKind: fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+		Namespace:        resource.GetNamespace(),
+		Name:             resource.GetName(),
+		Action:           action,
+		DryRun:           dryRun,
+		Ttl:              ttl,
+		Expiry:           expiry,
+		Reason:           reason,
+	}
+
+	rule := r.rule(ruleId)
+	if err != nil {
+		entry.Error = err.Error()
+		rule.Failed++
+	} else if !dryRun {
+		rule.Executed++
+	}
+
+	r.Resources = append(r.Resources, entry)
+}
+
+// finish sets the end of the run
+func (r *RunReport) finish(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime).Round(time.Millisecond).String()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// startRunReport starts the report for the current run
+func (j *Janitor) startRunReport() {
+	j.report = newRunReport(j.dryRun)
+	j.report.Cluster = j.cluster
+}
+
+// finishRunReport finishes the report of the current run and persists it (if a report backend is set)
+func (j *Janitor) finishRunReport(ctx context.Context, err error) {
+	report := j.report
+	if report == nil {
+		return
+	}
+	report.finish(err)
+
+	j.logger.Info(
+		"janitor run report",
+		slog.Int("rules", len(report.Rules)),
+		slog.Int("resources", len(report.Resources)),
+		slog.String("duration", report.Duration),
+	)
+
+	if j.reportBackend == nil {
+		return
+	}
+
+	if err := j.reportBackend.Save(ctx, j, report, j.reportRetention); err != nil {
+		j.logger.Error("unable to save run report", slog.String("backend", j.reportBackend.Name()), slog.Any("error", err))
+	}
+}
+
+// applyReportRetention returns the reports (JSON, ordered by time) which are retained,
+// reports which cannot be parsed are kept
+func applyReportRetention[T ~[]byte](retention ReportRetention, reports []T) []T {
+	if retention.MaxAge > 0 {
+		minStartTime := time.Now().Add(-retention.MaxAge)
+
+		ret := make([]T, 0, len(reports))
+		for _, data := range reports {
+			report := struct {
+				StartTime time.Time `json:"startTime"`
+			}{}
+			if err := json.Unmarshal(data, &report); err == nil && report.StartTime.Before(minStartTime) {
+				continue
+			}
+			ret = append(ret, data)
+		}
+		reports = ret
+	}
+
+	if retention.Keep > 0 && len(reports) > retention.Keep {
+		reports = reports[len(reports)-retention.Keep:]
+	}
+
+	return reports
+}
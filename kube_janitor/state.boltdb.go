@@ -0,0 +1,101 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	stateBoltDBBucket = []byte("conditions")
+)
+
+type (
+	// StateBackendBoltDBStore persists the state in a local BoltDB file (eg. on a PersistentVolume)
+	StateBackendBoltDBStore struct {
+		db     *bolt.DB
+		bucket []byte
+	}
+)
+
+// NewStateBackendBoltDB opens (or creates) the BoltDB file and creates the state backend
+func NewStateBackendBoltDB(path string) (*StateBackendBoltDBStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &StateBackendBoltDBStore{db: db, bucket: stateBoltDBBucket}, nil
+}
+
+// ForCluster returns a backend for the cluster which uses the same file but a separate bucket (multi-cluster mode)
+func (b *StateBackendBoltDBStore) ForCluster(cluster string) *StateBackendBoltDBStore {
+	return &StateBackendBoltDBStore{
+		db:     b.db,
+		bucket: []byte(string(stateBoltDBBucket) + "." + cluster),
+	}
+}
+
+func (b *StateBackendBoltDBStore) Name() string {
+	return StateBackendBoltDB
+}
+
+// Load reads all entries from the bucket
+func (b *StateBackendBoltDBStore) Load(ctx context.Context, j *Janitor) (map[string]StateEntry, error) {
+	entries := map[string]StateEntry{}
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			entry := StateEntry{}
+			if err := json.Unmarshal(value, &entry); err != nil {
+				// ignore broken entries, the condition is tracked again
+				return nil
+			}
+			entries[string(key)] = entry
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// Save replaces the bucket with the current entries
+func (b *StateBackendBoltDBStore) Save(ctx context.Context, j *Janitor, entries map[string]StateEntry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(b.bucket) != nil {
+			if err := tx.DeleteBucket(b.bucket); err != nil {
+				return err
+			}
+		}
+
+		bucket, err := tx.CreateBucket(b.bucket)
+		if err != nil {
+			return err
+		}
+
+		for key, entry := range entries {
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Close closes the BoltDB file
+func (b *StateBackendBoltDBStore) Close() error {
+	return b.db.Close()
+}
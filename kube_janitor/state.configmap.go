@@ -0,0 +1,109 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StateConfigMapDataKey = "state.json"
+
+	// StateConfigMapMaxSize is the max size of the state inside the ConfigMap (Kubernetes limits objects to 1MiB)
+	StateConfigMapMaxSize = 900 * 1024
+)
+
+type (
+	// StateBackendConfigMapStore persists the state as JSON inside a ConfigMap (limited to 1MiB by Kubernetes,
+	// the entries which were not seen for the longest time are pruned if the state exceeds StateConfigMapMaxSize)
+	StateBackendConfigMapStore struct {
+		namespace string
+		name      string
+	}
+)
+
+// NewStateBackendConfigMap creates the ConfigMap state backend, the ConfigMap is created on the first save
+func NewStateBackendConfigMap(namespace, name string) *StateBackendConfigMapStore {
+	return &StateBackendConfigMapStore{
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (b *StateBackendConfigMapStore) Name() string {
+	return StateBackendConfigMap
+}
+
+// Load reads the entries from the ConfigMap, a missing ConfigMap is an empty state
+func (b *StateBackendConfigMapStore) Load(ctx context.Context, j *Janitor) (map[string]StateEntry, error) {
+	entries := map[string]StateEntry{}
+
+	configMap, err := j.kubeClient.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if data, exists := configMap.Data[StateConfigMapDataKey]; exists && data != "" {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// Save writes the entries to the ConfigMap (creates the ConfigMap if it doesn't exist)
+func (b *StateBackendConfigMapStore) Save(ctx context.Context, j *Janitor, entries map[string]StateEntry) error {
+	data, pruned, err := marshalStateEntries(entries, StateConfigMapMaxSize)
+	if err != nil {
+		return err
+	}
+
+	if pruned > 0 {
+		j.logger.Warn(
+			"state exceeds the max ConfigMap size, pruned least recently seen entries",
+			slog.Int("pruned", pruned),
+			slog.Int("entries", len(entries)-pruned),
+		)
+	}
+
+	configMap, err := j.kubeClient.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: b.namespace,
+				Name:      b.name,
+				Labels: map[string]string{
+					KubeLabelManagedBy: KubeLabelManagedByValue,
+				},
+			},
+			Data: map[string]string{
+				StateConfigMapDataKey: string(data),
+			},
+		}
+		_, err = j.kubeClient.CoreV1().ConfigMaps(b.namespace).Create(ctx, configMap, metav1.CreateOptions{FieldManager: KubeFieldManager})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	// ConfigMaps created before the label was added are labeled too (excluded from orphan rules)
+	if configMap.Labels == nil {
+		configMap.Labels = map[string]string{}
+	}
+	configMap.Labels[KubeLabelManagedBy] = KubeLabelManagedByValue
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[StateConfigMapDataKey] = string(data)
+
+	_, err = j.kubeClient.CoreV1().ConfigMaps(b.namespace).Update(ctx, configMap, metav1.UpdateOptions{FieldManager: KubeFieldManager})
+	return err
+}
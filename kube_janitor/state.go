@@ -0,0 +1,177 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	StateBackendMemory    = "memory"
+	StateBackendBoltDB    = "boltdb"
+	StateBackendConfigMap = "configmap"
+	StateBackendLease     = "lease"
+)
+
+type (
+	// StateBackend persists the janitor state (first-seen and condition-since timestamps) between runs
+	StateBackend interface {
+		// Name returns the name of the backend
+		Name() string
+
+		// Load reads all state entries from the backend
+		Load(ctx context.Context, j *Janitor) (map[string]StateEntry, error)
+
+		// Save writes all state entries to the backend (replaces the stored state)
+		Save(ctx context.Context, j *Janitor, entries map[string]StateEntry) error
+	}
+
+	// StateEntry is one tracked condition
+	StateEntry struct {
+		// Since is the timestamp since when the condition is tracked
+		Since time.Time `json:"since"`
+
+		// LastSeen is the timestamp when the condition was refreshed by a run (used for expiry)
+		LastSeen time.Time `json:"lastSeen"`
+	}
+
+	// stateStore holds the state entries in memory, loaded from and saved to the backend once per run
+	stateStore struct {
+		backend StateBackend
+
+		entries map[string]StateEntry
+		loaded  bool
+		changed bool
+
+		lock sync.RWMutex
+	}
+)
+
+// newStateStore creates the state store with the backend
+func newStateStore(backend StateBackend) *stateStore {
+	return &stateStore{
+		backend: backend,
+		entries: map[string]StateEntry{},
+	}
+}
+
+// load loads the state from the backend (only once, the store is the leading copy afterwards)
+func (s *stateStore) load(ctx context.Context, j *Janitor) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.loaded {
+		return nil
+	}
+
+	entries, err := s.backend.Load(ctx, j)
+	if err != nil {
+		return fmt.Errorf(`unable to load state from %s backend: %w`, s.backend.Name(), err)
+	}
+
+	if entries != nil {
+		s.entries = entries
+	}
+	s.loaded = true
+
+	return nil
+}
+
+// save removes expired entries and saves the state to the backend (if changed)
+func (s *stateStore) save(ctx context.Context, j *Janitor) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	expiry := time.Now().Add(-ConditionCacheExpiry)
+	for key, entry := range s.entries {
+		if entry.LastSeen.Before(expiry) {
+			delete(s.entries, key)
+			s.changed = true
+		}
+	}
+
+	if !s.changed {
+		return nil
+	}
+
+	if err := s.backend.Save(ctx, j, maps.Clone(s.entries)); err != nil {
+		return fmt.Errorf(`unable to save state to %s backend: %w`, s.backend.Name(), err)
+	}
+	s.changed = false
+
+	return nil
+}
+
+// get returns the timestamp of a tracked condition
+func (s *stateStore) get(key string) (time.Time, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if entry, exists := s.entries[key]; exists {
+		return entry.Since, true
+	}
+
+	return time.Time{}, false
+}
+
+// set tracks the timestamp of a condition and refreshes the expiry
+func (s *stateStore) set(key string, val time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// lastSeen is only updated once per hour to avoid a backend write on every run
+	entry, exists := s.entries[key]
+	if exists && entry.Since.Equal(val) && time.Since(entry.LastSeen) < time.Hour {
+		return
+	}
+
+	s.entries[key] = StateEntry{Since: val, LastSeen: time.Now()}
+	s.changed = true
+}
+
+// delete removes a tracked condition
+func (s *stateStore) delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.entries[key]; exists {
+		delete(s.entries, key)
+		s.changed = true
+	}
+}
+
+// marshalStateEntries encodes the entries as JSON, if the data exceeds maxSize the entries which were not seen
+// for the longest time are pruned until the data fits, returns the data and the count of pruned entries
+func marshalStateEntries(entries map[string]StateEntry, maxSize int) ([]byte, int, error) {
+	data, err := json.Marshal(entries)
+	if err != nil || len(data) <= maxSize {
+		return data, 0, err
+	}
+
+	keys := slices.Collect(maps.Keys(entries))
+	slices.SortFunc(keys, func(a, b string) int {
+		return entries[a].LastSeen.Compare(entries[b].LastSeen)
+	})
+
+	entries = maps.Clone(entries)
+	pruned := 0
+	for len(data) > maxSize && pruned < len(keys) {
+		// remove the share of entries which exceeds the size (at least one entry)
+		count := max(1, len(entries)*(len(data)-maxSize)/len(data)+1)
+		for _, key := range keys[pruned:min(pruned+count, len(keys))] {
+			delete(entries, key)
+		}
+		pruned = min(pruned+count, len(keys))
+
+		data, err = json.Marshal(entries)
+		if err != nil {
+			return nil, pruned, err
+		}
+	}
+
+	return data, pruned, nil
+}
@@ -0,0 +1,117 @@
+package kube_janitor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StateLeaseAnnotation = "janitor/state"
+
+	// StateLeaseMaxSize is the max size of the state inside the Lease (Kubernetes limits all annotations to 256KiB)
+	StateLeaseMaxSize = 240 * 1024
+)
+
+type (
+	// StateBackendLeaseStore persists the state as JSON inside an annotation of a Lease (coordination.k8s.io/v1),
+	// the entries which were not seen for the longest time are pruned if the state exceeds StateLeaseMaxSize
+	StateBackendLeaseStore struct {
+		namespace string
+		name      string
+	}
+)
+
+// NewStateBackendLease creates the Lease state backend, the Lease is created on the first save
+func NewStateBackendLease(namespace, name string) *StateBackendLeaseStore {
+	return &StateBackendLeaseStore{
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (b *StateBackendLeaseStore) Name() string {
+	return StateBackendLease
+}
+
+// Load reads the entries from the Lease, a missing Lease is an empty state
+func (b *StateBackendLeaseStore) Load(ctx context.Context, j *Janitor) (map[string]StateEntry, error) {
+	entries := map[string]StateEntry{}
+
+	lease, err := j.kubeClient.CoordinationV1().Leases(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if data, exists := lease.Annotations[StateLeaseAnnotation]; exists && data != "" {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// Save writes the entries to the Lease (creates the Lease if it doesn't exist), the renew time is the time of the last save
+func (b *StateBackendLeaseStore) Save(ctx context.Context, j *Janitor, entries map[string]StateEntry) error {
+	data, pruned, err := marshalStateEntries(entries, StateLeaseMaxSize)
+	if err != nil {
+		return err
+	}
+
+	if pruned > 0 {
+		j.logger.Warn(
+			"state exceeds the max Lease annotation size, pruned least recently seen entries",
+			slog.Int("pruned", pruned),
+			slog.Int("entries", len(entries)-pruned),
+		)
+	}
+
+	holderIdentity := KubeLabelManagedByValue
+	renewTime := metav1.NewMicroTime(time.Now())
+
+	lease, err := j.kubeClient.CoordinationV1().Leases(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: b.namespace,
+				Name:      b.name,
+				Labels: map[string]string{
+					KubeLabelManagedBy: KubeLabelManagedByValue,
+				},
+				Annotations: map[string]string{
+					StateLeaseAnnotation: string(data),
+				},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity: &holderIdentity,
+				RenewTime:      &renewTime,
+			},
+		}
+		_, err = j.kubeClient.CoordinationV1().Leases(b.namespace).Create(ctx, lease, metav1.CreateOptions{FieldManager: KubeFieldManager})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if lease.Labels == nil {
+		lease.Labels = map[string]string{}
+	}
+	lease.Labels[KubeLabelManagedBy] = KubeLabelManagedByValue
+
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[StateLeaseAnnotation] = string(data)
+	lease.Spec.RenewTime = &renewTime
+
+	_, err = j.kubeClient.CoordinationV1().Leases(b.namespace).Update(ctx, lease, metav1.UpdateOptions{FieldManager: KubeFieldManager})
+	return err
+}
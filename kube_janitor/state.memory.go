@@ -0,0 +1,29 @@
+package kube_janitor
+
+import (
+	"context"
+)
+
+type (
+	// StateBackendMemoryStore keeps the state only in memory (lost on restart)
+	StateBackendMemoryStore struct{}
+)
+
+// NewStateBackendMemory creates the in-memory state backend
+func NewStateBackendMemory() *StateBackendMemoryStore {
+	return &StateBackendMemoryStore{}
+}
+
+func (b *StateBackendMemoryStore) Name() string {
+	return StateBackendMemory
+}
+
+// Load returns no entries, the state store itself is the in-memory storage
+func (b *StateBackendMemoryStore) Load(ctx context.Context, j *Janitor) (map[string]StateEntry, error) {
+	return nil, nil
+}
+
+// Save is a noop for the in-memory backend
+func (b *StateBackendMemoryStore) Save(ctx context.Context, j *Janitor, entries map[string]StateEntry) error {
+	return nil
+}
@@ -2,15 +2,16 @@ package kube_janitor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/log/slogger"
 	prometheusCommon "github.com/webdevops/go-common/prometheus"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -20,75 +21,183 @@ const (
 
 // runRule executes one ConfigRule ttl run
 func (j *Janitor) runRule(ctx context.Context, logger *slogger.Logger, rule *ConfigRule, metricList *prometheusCommon.MetricList, filterFunc func(rule *ConfigRule, resource unstructured.Unstructured) (string, bool)) error {
+	match, err := j.matchRule(ctx, logger, rule, filterFunc)
+	if err != nil {
+		return err
+	}
+
+	j.processRule(ctx, logger, rule, match, metricList)
+
+	return nil
+}
+
+// matchRule finds all resources matching the ConfigRule (by namespace), the resources are not processed yet
+func (j *Janitor) matchRule(ctx context.Context, logger *slogger.Logger, rule *ConfigRule, filterFunc func(rule *ConfigRule, resource unstructured.Unstructured) (string, bool)) (*ruleMatch, error) {
 	startTime := time.Now()
 	ruleLogger := logger.With(
 		slog.String("rule", rule.String()),
 	)
 	ruleLogger.Info(`starting rule`)
 
-	var namespaced bool
-	if !rule.NamespaceSelector.IsEmpty() {
-		// if we have a namespace selector, we have to lookup matching all namespaces
-		// and executes the rule within these namespaces.
-		// this automatically excludes cluster resources (non-namespaced) as they are
-		// not part of any namespace.
-		namespaced = true
-	}
+	ctx, span := j.startSpan(ctx, "janitor.matchRule", TracingAttrRule.String(rule.Id))
+	defer span.End()
+
+	// if we have a namespace selector or patterns, we have to lookup matching all namespaces
+	// and executes the rule within these namespaces.
+	// this automatically excludes cluster resources (non-namespaced) as they are
+	// not part of any namespace.
+	namespaced := rule.isNamespaced()
 
-	resourceList, err := j.kubeLookupGvkList(rule.Resources, namespaced)
+	// stuck resources are reported again by this run
+	j.prometheus.resourceTerminating.DeletePartialMatch(prometheus.Labels{"rule": rule.Id})
+
+	resourceList, err := j.kubeLookupGvkList(ctx, rule.Resources, namespaced)
 	if err != nil {
-		return err
+		spanRecordError(span, err)
+		return nil, err
+	}
+
+	match := &ruleMatch{
+		candidates: map[string][]*ruleCandidate{},
 	}
 
 	// build namespace list
-	var namespaceList []string
 	if namespaced {
 		err = j.kubeEachNamespace(ctx, rule.NamespaceSelector, func(namespace corev1.Namespace) error {
-			namespaceList = append(namespaceList, namespace.Name)
+			if matchesIncludeExclude(namespace.Name, rule.Namespaces, rule.ExcludeNamespaces) {
+				match.namespaces = append(match.namespaces, namespace.Name)
+			}
 			return nil
 		})
 		if err != nil {
-			return err
+			spanRecordError(span, err)
+			return nil, err
 		}
 	} else {
 		// we fake an empty namespace (=get resources from the cluster view)
-		namespaceList = append(namespaceList, KubeNoNamespace)
+		match.namespaces = append(match.namespaces, KubeNoNamespace)
 	}
 
-	// find resources, check and process them
-	for _, namespace := range namespaceList {
+	span.SetAttributes(
+		TracingAttrNamespaceCount.Int(len(match.namespaces)),
+		TracingAttrResourceTypeCount.Int(len(resourceList)),
+	)
+
+	// find resources and check them
+	candidateCount := 0
+	for _, namespace := range match.namespaces {
+		namespaceLogger := ruleLogger
+		if namespace != KubeNoNamespace {
+			namespaceLogger = namespaceLogger.With(slog.String("namespace", namespace))
+		}
+
+		// collect all matching resources first to resolve the owners (dependency graph)
+		candidates := []*ruleCandidate{}
 		for _, resourceType := range resourceList {
-			gvkLogger := ruleLogger.With(slog.String("groupVersionKind", resourceType.String()))
-			if namespace != KubeNoNamespace {
-				gvkLogger = gvkLogger.With(slog.String("namespace", namespace))
-			}
+			gvkLogger := namespaceLogger.With(slog.String("groupVersionKind", resourceType.String()))
 
 			gvkLogger.Info("checking resources")
-			err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace, resourceType.Selector, func(resource unstructured.Unstructured) error {
+			err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace, resourceType.Selector, resourceType.FieldSelector, func(resource unstructured.Unstructured) error {
+				groupVersionKind := resource.GroupVersionKind()
+				gvkLabel := fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind)
+				j.prometheus.resourceScanned.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel}).Inc()
+
+				// name patterns are cheap, check them before any JMES path
+				if !matchesIncludeExclude(resource.GetName(), resourceType.Names, resourceType.ExcludeNames) {
+					j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "reason": MetricSkipReasonName}).Inc()
+					return nil
+				}
+
+				// resource has no ttl (annotation, label or static rule), most resources are skipped here
 				ttl, ok := filterFunc(rule, resource)
 				if !ok || ttl == "" {
+					j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "reason": MetricSkipReasonNoTtl}).Inc()
 					return nil
 				}
 
-				return j.checkResourceTtlAndTriggerDeleteIfExpired(
-					ctx,
-					gvkLogger,
-					resourceType,
-					resource,
-					rule,
-					ttl,
-					metricList,
-				)
+				matched, err := j.checkResourceMatchesFilterPath(ctx, gvkLogger, resourceType, resource, rule)
+				if err != nil || !matched {
+					return err
+				}
+
+				if j.isResourceExcluded(gvkLogger, rule.Id, gvkLabel, &resource) {
+					return nil
+				}
+
+				// terminating resources are not processed again, only checked for stuck finalizers
+				if resource.GetDeletionTimestamp() != nil {
+					j.checkResourceTerminating(ctx, gvkLogger, resourceType, resource, rule)
+					return nil
+				}
+
+				candidates = append(candidates, &ruleCandidate{
+					logger:         gvkLogger,
+					resourceConfig: resourceType,
+					resource:       resource,
+					ttl:            ttl,
+				})
+				return nil
 			})
 			if err != nil {
 				gvkLogger.Error("failed to list resources", slog.Any("error", err))
 			}
 		}
+
+		candidateCount += len(candidates)
+		match.candidates[namespace] = candidates
 	}
 
-	logger.Info("finished rule", slog.Duration("duration", time.Since(startTime)))
+	span.SetAttributes(TracingAttrItems.Int(candidateCount))
+	match.duration = time.Since(startTime)
 
-	return nil
+	return match, nil
+}
+
+// processRule resolves the owners of the matched resources and processes them (ttl check and action)
+func (j *Janitor) processRule(ctx context.Context, logger *slogger.Logger, rule *ConfigRule, match *ruleMatch, metricList *prometheusCommon.MetricList) {
+	startTime := time.Now()
+
+	ctx, span := j.startSpan(ctx, "janitor.runRule", TracingAttrRule.String(rule.Id))
+	defer span.End()
+
+	ruleLogger := logger.With(
+		slog.String("rule", rule.String()),
+	)
+
+	candidateCount := 0
+	for _, namespace := range match.namespaces {
+		namespaceLogger := ruleLogger
+		if namespace != KubeNoNamespace {
+			namespaceLogger = namespaceLogger.With(slog.String("namespace", namespace))
+		}
+
+		candidates := match.candidates[namespace]
+		candidateCount += len(candidates)
+		for _, candidate := range j.resolveRuleCandidateOwners(ctx, namespaceLogger, rule, candidates) {
+			err := j.checkResourceTtlAndTriggerDeleteIfExpired(
+				ctx,
+				candidate.logger,
+				candidate.resourceConfig,
+				candidate.resource,
+				rule,
+				candidate.ttl,
+				metricList,
+			)
+			if err != nil {
+				candidate.logger.Error(
+					"failed to process resource",
+					slog.String("namespace", candidate.resource.GetNamespace()),
+					slog.String("name", candidate.resource.GetName()),
+					slog.Any("error", err),
+				)
+			}
+		}
+	}
+
+	duration := match.duration + time.Since(startTime)
+	span.SetAttributes(TracingAttrItems.Int(candidateCount))
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(duration.Seconds())
+	logger.Info("finished rule", slog.Duration("duration", duration))
 }
 
 // checkResourceTtlAndTriggerDeleteIfExpired checks the resource against the defined TTL and deletes if the resource is expired
@@ -99,102 +208,224 @@ func (j *Janitor) checkResourceTtlAndTriggerDeleteIfExpired(ctx context.Context,
 		slog.String("ttl", ttlValue),
 	)
 
-	groupVersionKind := resource.GroupVersionKind()
-
 	// no ttl, no processing
 	// better safe than sorry
 	if ttlValue == "" {
 		return nil
 	}
 
-	// check if resource is filtered
-	if !resourceConfig.FilterPath.IsEmpty() {
-		skipped, err := j.checkResourceIsSkippedFromJmesPath(resource, resourceConfig.FilterPath)
-		if err != nil {
-			return err
+	var timestamp *time.Time
+	if rule.conditionFor > 0 {
+		// use the first match (filterPath became true) of the resource,
+		// the resource is not processed before the condition is true for conditionFor
+		timestamp = j.conditionSince(ruleConditionKey(rule, resource), true)
+		if since := time.Since(*timestamp); since < rule.conditionFor {
+			resourceLogger.Debug("condition is not yet true for conditionFor", slog.Time("firstMatch", *timestamp))
+			if rule.Approval != nil {
+				return j.resetResourceApproval(ctx, resourceLogger, resourceConfig, resource)
+			}
+			return nil
 		}
-
-		if skipped {
-			resourceLogger.Debug("resource skipped by JMES path")
+	} else {
+		// use creation timesstamp by default
+		// use timestamp from jmespath, managedFields, pods or last-used annotation as alterantive (if configured)
+		timestamp = j.fetchResourceTimestamp(ctx, resourceLogger, resourceConfig, resource)
+		if timestamp == nil {
 			return nil
 		}
 	}
 
-	// use creation timesstamp by default
-	// use timestamp from jmespath as alterantive (if configured)
-	timestamp := resource.GetCreationTimestamp().Time
-	if !resourceConfig.TimestampPath.IsEmpty() {
-		val, err := j.parseResourceTimestampFromJmesPath(resource, resourceConfig.TimestampPath)
-		if err != nil {
-			resourceLogger.Warn("parse resource timestamp from jmesPath failed", slog.Any("error", err))
-			return nil
-		} else if val == nil {
-			resourceLogger.Debug("parse resource timestamp from jmesPath failed")
-			return nil
+	return j.checkResourceExpiryAndTriggerDelete(ctx, resourceLogger, resourceConfig, resource, rule, ttlValue, *timestamp, metricResourceTtl)
+}
+
+// checkResourceMatchesFilterPath checks if the resource is selected by the filterPath (if configured)
+func (j *Janitor) checkResourceMatchesFilterPath(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	if resourceConfig.FilterPath.IsEmpty() {
+		return true, nil
+	}
+
+	_, span := j.startSpan(ctx, "janitor.filterPath", TracingAttrRule.String(rule.Id), TracingAttrNamespace.String(resource.GetNamespace()), TracingAttrName.String(resource.GetName()))
+	skipped, err := j.checkResourceIsSkippedFromJmesPath(resource, resourceConfig.FilterPath)
+	spanRecordError(span, err)
+	span.End()
+	if err != nil {
+		j.prometheus.parseErrors.With(prometheus.Labels{"rule": rule.Id, "type": MetricParseErrorFilterPath}).Inc()
+		return false, err
+	}
+
+	if skipped {
+		logger.Debug("resource skipped by JMES path", slog.String("namespace", resource.GetNamespace()), slog.String("name", resource.GetName()))
+		groupVersionKind := resource.GroupVersionKind()
+		j.prometheus.resourceSkipped.With(
+			prometheus.Labels{
+				"rule":             rule.Id,
+				"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+				"reason":           MetricSkipReasonFilterPath,
+			},
+		).Inc()
+		if rule.conditionFor > 0 {
+			// condition is not true anymore, reset first match
+			j.conditionDelete(ruleConditionKey(rule, resource))
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// checkResourceExpiryAndTriggerDelete checks the TTL against the timestamp and deletes the resource if it is expired
+func (j *Janitor) checkResourceExpiryAndTriggerDelete(ctx context.Context, resourceLogger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured, rule *ConfigRule, ttlValue string, timestamp time.Time, metricResourceTtl *prometheusCommon.MetricList) error {
+	groupVersionKind := resource.GroupVersionKind()
+	gvkLabel := fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind)
+
+	j.prometheus.resourceMatched.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel}).Inc()
+
+	// resource is protected by the owner
+	if j.isResourceKept(&resource) {
+		resourceLogger.Debug("resource is protected by keep annotation")
+		j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "reason": MetricSkipReasonProtected}).Inc()
+		if rule.Approval != nil {
+			return j.resetResourceApproval(ctx, resourceLogger, resourceConfig, resource)
 		}
+		return nil
+	}
 
-		timestamp = *val
+	extension, err := j.parseResourceExpiryExtension(resource, rule.maxLifetime)
+	if err != nil {
+		resourceLogger.Warn("ignoring invalid ttl extension", slog.Any("error", err))
+		j.prometheus.parseErrors.With(prometheus.Labels{"rule": rule.Id, "type": MetricParseErrorExtension}).Inc()
+		extension = nil
 	}
 
-	parsedDate, expired, err := j.checkExpiryDate(timestamp, ttlValue)
+	parsedDate, expired, err := j.checkExpiryDate(timestamp, ttlValue, extension)
 	if err != nil {
 		resourceLogger.Error("unable to parse expiration date", slog.String("raw", ttlValue), slog.Any("error", err))
+		j.prometheus.parseErrors.With(prometheus.Labels{"rule": rule.Id, "type": MetricParseErrorTtl}).Inc()
+		j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "reason": MetricSkipReasonUnparseableTtl}).Inc()
 		return nil
 	}
 
-	resourceLogger.Debug("found resource with valid TTL", slog.Time("expiry", *parsedDate))
+	j.report.addChecked(rule.Id, expired)
+
+	extensionCount := 0
+	if extension != nil {
+		extensionCount = extension.Count()
+	}
+
+	resourceLogger.Debug("found resource with valid TTL", slog.Time("expiry", *parsedDate), slog.Int("extensions", extensionCount))
+
+	if rule.Approval != nil {
+		if expired {
+			// two-phase approval: mark first, execute action after the confirmation delay
+			// (not needed if the action is already applied, eg. already scaled to zero)
+			applied, err := j.isActionApplied(ctx, resourceConfig, resource, rule.GetAction())
+			if errors.Is(err, errActionNotSupported) {
+				resourceLogger.Warn("action is not supported for resource, skipping")
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if !applied {
+				approved, err := j.checkResourceApproval(ctx, resourceLogger, resourceConfig, resource, rule, ttlValue, *parsedDate)
+				if err != nil || !approved {
+					return err
+				}
+			}
+		} else if err := j.resetResourceApproval(ctx, resourceLogger, resourceConfig, resource); err != nil {
+			return err
+		}
+	}
 
 	if expired {
+		action := rule.GetAction()
+		actionLogger := resourceLogger.With(slog.String("action", action.Name()))
+
 		if j.dryRun {
-			resourceLogger.Info("resource is expired, would delete resource (DRY-RUN)", slog.Time("expirationDate", *parsedDate))
+			actionLogger.Info("resource is expired, would execute action on resource (DRY-RUN)", slog.Time("expirationDate", *parsedDate))
+			j.report.addResource(rule.Id, resource, action.Name(), ttlValue, *parsedDate, "TimeToLiveExpired", true, nil)
+			j.audit(rule.Id, action.Name(), resource, ttlValue, parsedDate, false, nil)
 		} else {
-			resourceLogger.Info("deleting expired resource", slog.Time("expirationDate", *parsedDate))
-			deleteOpts := metav1.DeleteOptions{}
-			if rule.DeleteOptions.PropagationPolicy != nil {
-				propagationPolicy := metav1.DeletionPropagation(*rule.DeleteOptions.PropagationPolicy)
-				deleteOpts.PropagationPolicy = &propagationPolicy
-			}
-			if rule.DeleteOptions.GracePeriodSeconds != nil {
-				deleteOpts.GracePeriodSeconds = rule.DeleteOptions.GracePeriodSeconds
+			actionLogger.Info("executing action on expired resource", slog.Time("expirationDate", *parsedDate))
+			executed, err := action.Execute(ctx, j, resourceConfig.AsGVR(), resource, rule)
+			if errors.Is(err, errActionNotSupported) {
+				actionLogger.Warn("action is not supported for resource, skipping")
+				return nil
+			} else if errors.Is(err, errActionPostponed) {
+				actionLogger.Info("action is postponed to the next run", slog.String("reason", err.Error()))
+				return nil
 			}
 
-			err := j.dynClient.Resource(resourceConfig.AsGVR()).Namespace(resource.GetNamespace()).Delete(ctx, resource.GetName(), deleteOpts)
+			// actions which were already applied (no change) are not audited
+			if executed || err != nil {
+				j.audit(rule.Id, action.Name(), resource, ttlValue, parsedDate, executed, err)
+			}
 			if err != nil {
+				j.prometheus.actionFailed.With(
+					prometheus.Labels{
+						"rule":             rule.Id,
+						"groupVersionKind": gvkLabel,
+						"namespace":        resource.GetNamespace(),
+						"action":           action.Name(),
+					},
+				).Inc()
+				j.report.addResource(rule.Id, resource, action.Name(), ttlValue, *parsedDate, "TimeToLiveExpired", false, err)
 				return err
 			}
 
+			if rule.Approval != nil {
+				if err := j.finishResourceApproval(ctx, actionLogger, resourceConfig, resource, action); err != nil {
+					actionLogger.Error("unable to remove mark for deletion", slog.Any("error", err))
+				}
+			}
+
+			if !executed {
+				actionLogger.Debug("action was already executed on resource")
+				return nil
+			}
+
+			metricLabels := prometheus.Labels{
+				"rule":             rule.Id,
+				"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+				"namespace":        resource.GetNamespace(),
+			}
+
 			// increase deleted counter
-			j.prometheus.deleted.With(
-				prometheus.Labels{
-					"rule":             rule.Id,
-					"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
-					"namespace":        resource.GetNamespace(),
-				},
-			).Inc()
+			if action.Name() == ActionTypeDelete {
+				j.prometheus.deleted.With(metricLabels).Inc()
+			}
+
+			metricLabels["action"] = action.Name()
+			j.prometheus.action.With(metricLabels).Inc()
 
 			reason := "TimeToLiveExpired"
-			message := fmt.Sprintf(`TTL of "%v" is expired and resource is being deleted (%s)`, ttlValue, rule.Id)
+			j.report.addResource(rule.Id, resource, action.Name(), ttlValue, *parsedDate, reason, false, nil)
+
+			// resource matched by multiple rules, record the overruled rules (see OverlapPolicy*)
+			ruleDescription := rule.Id
+			if overruledRules := j.ruleOverruled[ruleOverruledKey(rule, resource.GetUID())]; len(overruledRules) > 0 {
+				ruleDescription = fmt.Sprintf("%s, overrules %s", rule.Id, strings.Join(overruledRules, ", "))
+			}
 
-			err = j.kubeCreateEventFromResource(ctx, resource.GetNamespace(), resource, message, reason)
+			message := fmt.Sprintf(`TTL of "%v" is expired and %s (%s)`, ttlValue, action.Description(), ruleDescription)
+			if extensionCount > 0 {
+				message = fmt.Sprintf(`TTL of "%v" (extended %d times until %s) is expired and %s (%s)`, ttlValue, extensionCount, parsedDate.Format(time.RFC3339), action.Description(), ruleDescription)
+			}
+
+			err = j.kubeCreateEventFromResource(ctx, resource.GetNamespace(), resource, action.EventAction(), message, reason)
 			if err != nil {
 				resourceLogger.Error("unable to create Kubernetes Event", slog.Any("error", err))
 			}
 		}
 	} else {
 		// resource not yet expired, but add expiry as metric
-
-		metricResourceTtl.AddTime(
-			prometheus.Labels{
-				"rule":             rule.Id,
-				"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
-				"namespace":        resource.GetNamespace(),
-				"name":             resource.GetName(),
-				"ttl":              ttlValue,
-			},
-			*parsedDate,
-		)
-
+		j.addResourceExpiryMetric(metricResourceTtl, rule, resource, ttlValue, *parsedDate, extensionCount)
 	}
 
 	return nil
 }
+
+// ruleConditionKey returns the state key for the first match of a resource by a rule
+func ruleConditionKey(rule *ConfigRule, resource unstructured.Unstructured) string {
+	return "rule." + rule.Id + "." + string(resource.GetUID())
+}
@@ -0,0 +1,354 @@
+package kube_janitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	HelmReleaseSecretType = "helm.sh/release.v1"
+
+	HelmReleaseLabelOwner   = "owner"
+	HelmReleaseLabelName    = "name"
+	HelmReleaseLabelVersion = "version"
+	HelmReleaseLabelStatus  = "status"
+
+	HelmReleaseOwner = "helm"
+
+	// HelmResourcePolicyAnnotation with value "keep" prevents the deletion of the object on uninstall (same as helm)
+	HelmResourcePolicyAnnotation = "helm.sh/resource-policy"
+	HelmResourcePolicyKeep       = "keep"
+)
+
+var (
+	helmReleaseSecretResource = &ConfigResource{Group: "", Version: "v1", Kind: "secrets"}
+
+	helmReleaseSecretGVR = helmReleaseSecretResource.AsGVR()
+
+	errHelmReleaseNoPayload = errors.New("helm release secret has no release payload")
+)
+
+type (
+	// helmRelease is the subset of the helm release payload (stored in the release secret) used by the janitor
+	helmRelease struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Version   int               `json:"version"`
+		Manifest  string            `json:"manifest"`
+		Labels    map[string]string `json:"labels"`
+		Config    map[string]any    `json:"config"`
+		Info      struct {
+			Status        string    `json:"status"`
+			FirstDeployed time.Time `json:"first_deployed"`
+			LastDeployed  time.Time `json:"last_deployed"`
+		} `json:"info"`
+		Chart struct {
+			Metadata struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"metadata"`
+			Values map[string]any `json:"values"`
+		} `json:"chart"`
+	}
+)
+
+// runHelm executes the helm rules from the configuration file
+func (j *Janitor) runHelm(ctx context.Context) error {
+	metricResourceRule := prometheusCommon.NewMetricsList()
+
+	for _, rule := range j.config.Helm {
+		err := j.runHelmRule(ctx, j.logger, rule, metricResourceRule)
+		if err != nil {
+			return err
+		}
+	}
+
+	metricResourceRule.GaugeSet(j.prometheus.rule)
+
+	return nil
+}
+
+// runHelmRule executes one ConfigHelmRule run, the releases are discovered from their storage secrets
+func (j *Janitor) runHelmRule(ctx context.Context, logger *slogger.Logger, helmRule *ConfigHelmRule, metricList *prometheusCommon.MetricList) error {
+	startTime := time.Now()
+	ruleLogger := logger.With(
+		slog.String("rule", helmRule.String()),
+	)
+	ruleLogger.Info(`starting helm rule`)
+
+	// faked rule for helm handling
+	rule := &ConfigRule{
+		Id:                helmRule.Id,
+		NamespaceSelector: helmRule.NamespaceSelector,
+		Action:            &ConfigRuleAction{Type: ActionTypeHelmUninstall, action: &janitorActionHelmUninstall{}},
+		DeleteOptions:     helmRule.DeleteOptions,
+	}
+
+	var namespaceList []string
+	if !helmRule.NamespaceSelector.IsEmpty() {
+		err := j.kubeEachNamespace(ctx, helmRule.NamespaceSelector, func(namespace corev1.Namespace) error {
+			namespaceList = append(namespaceList, namespace.Name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		namespaceList = append(namespaceList, KubeNoNamespace)
+	}
+
+	for _, namespace := range namespaceList {
+		namespaceLogger := ruleLogger
+		if namespace != KubeNoNamespace {
+			namespaceLogger = namespaceLogger.With(slog.String("namespace", namespace))
+		}
+
+		// find the latest release secret (highest revision) of every release
+		latestReleases := map[string]unstructured.Unstructured{}
+		err := j.kubeEachResource(ctx, helmReleaseSecretGVR, namespace, helmRule.Selector, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+			if secretType, _, _ := unstructured.NestedString(resource.Object, "type"); secretType != HelmReleaseSecretType {
+				return nil
+			}
+
+			key := resource.GetNamespace() + "/" + resource.GetLabels()[HelmReleaseLabelName]
+			if current, exists := latestReleases[key]; !exists || helmReleaseRevision(resource) > helmReleaseRevision(current) {
+				latestReleases[key] = resource
+			}
+			return nil
+		})
+		if err != nil {
+			namespaceLogger.Error("failed to list helm release secrets", slog.Any("error", err))
+			continue
+		}
+
+		for _, resource := range latestReleases {
+			releaseLogger := namespaceLogger.WithGroup("resource").With(
+				slog.String("namespace", resource.GetNamespace()),
+				slog.String("release", resource.GetLabels()[HelmReleaseLabelName]),
+				slog.Int("revision", helmReleaseRevision(resource)),
+			)
+
+			if status := resource.GetLabels()[HelmReleaseLabelStatus]; strings.HasPrefix(status, "pending-") || status == "uninstalling" {
+				releaseLogger.Debug("helm release is in progress, skipping", slog.String("status", status))
+				continue
+			}
+
+			if j.isResourceExcluded(releaseLogger, rule.Id, "/v1/Secret", &resource) {
+				continue
+			}
+
+			release, err := decodeHelmRelease(resource)
+			if err != nil {
+				releaseLogger.Warn("unable to decode helm release, skipping", slog.Any("error", err))
+				continue
+			}
+
+			ttlValue := helmRule.releaseTtl(resource, release)
+			if ttlValue == "" {
+				continue
+			}
+
+			releaseLogger = releaseLogger.With(slog.String("ttl", ttlValue), slog.String("chart", release.Chart.Metadata.Name+"-"+release.Chart.Metadata.Version))
+
+			err = j.checkResourceExpiryAndTriggerDelete(ctx, releaseLogger, helmReleaseSecretResource, resource, rule, ttlValue, release.Info.FirstDeployed, metricList)
+			if err != nil {
+				releaseLogger.Error("failed to process helm release", slog.Any("error", err))
+			}
+		}
+	}
+
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(time.Since(startTime).Seconds())
+	ruleLogger.Info("finished helm rule", slog.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+// releaseTtl returns the ttl of the release (release label, chart values or static ttl)
+func (c *ConfigHelmRule) releaseTtl(resource unstructured.Unstructured, release *helmRelease) string {
+	if c.TtlLabel != "" {
+		// custom release labels are stored inside the payload and as secret labels
+		if val := strings.TrimSpace(release.Labels[c.TtlLabel]); val != "" {
+			return val
+		}
+		if val := strings.TrimSpace(resource.GetLabels()[c.TtlLabel]); val != "" {
+			return val
+		}
+	}
+
+	if !c.TtlValuesPath.IsEmpty() {
+		// user supplied values first, chart default values as fallback
+		for _, values := range []map[string]any{release.Config, release.Chart.Values} {
+			if values == nil {
+				continue
+			}
+
+			result, err := c.TtlValuesPath.compiledPath.Search(values)
+			if err != nil || result == nil {
+				continue
+			}
+
+			if val, ok := result.(string); ok && strings.TrimSpace(val) != "" {
+				return strings.TrimSpace(val)
+			}
+		}
+	}
+
+	return c.Ttl
+}
+
+// helmReleaseRevision returns the revision of a release secret
+func helmReleaseRevision(resource unstructured.Unstructured) int {
+	revision, err := strconv.Atoi(resource.GetLabels()[HelmReleaseLabelVersion])
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// decodeHelmRelease decodes the release payload of a helm release secret (base64 encoded, gzipped JSON)
+func decodeHelmRelease(resource unstructured.Unstructured) (*helmRelease, error) {
+	raw, exists, err := unstructured.NestedString(resource.Object, "data", "release")
+	if err != nil {
+		return nil, err
+	} else if !exists || raw == "" {
+		return nil, errHelmReleaseNoPayload
+	}
+
+	// secret data itself is base64 encoded
+	payload, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to decode secret data: %w`, err)
+	}
+
+	// helm payload is base64 encoded again
+	payload, err = base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return nil, fmt.Errorf(`unable to decode release payload: %w`, err)
+	}
+
+	// gzipped (magic header), older releases might be uncompressed
+	if len(payload) > 3 && bytes.Equal(payload[0:3], []byte{0x1f, 0x8b, 0x08}) {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf(`unable to decompress release payload: %w`, err)
+		}
+		defer reader.Close() // nolint:errcheck
+
+		payload, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to decompress release payload: %w`, err)
+		}
+	}
+
+	release := &helmRelease{}
+	if err := json.Unmarshal(payload, release); err != nil {
+		return nil, fmt.Errorf(`unable to parse release payload: %w`, err)
+	}
+
+	return release, nil
+}
+
+// helmReleaseManifestObjects parses the manifest of the release (multiple YAML documents)
+func helmReleaseManifestObjects(release *helmRelease) ([]unstructured.Unstructured, error) {
+	ret := []unstructured.Unstructured{}
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(release.Manifest), 4096)
+	for {
+		obj := map[string]any{}
+		if err := decoder.Decode(&obj); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// empty documents
+		if len(obj) == 0 {
+			continue
+		}
+
+		ret = append(ret, unstructured.Unstructured{Object: obj})
+	}
+
+	return ret, nil
+}
+
+// kubeDeleteHelmReleaseObject deletes one manifest object of a release, returns false if the object is kept or already gone
+func (j *Janitor) kubeDeleteHelmReleaseObject(ctx context.Context, logger *slogger.Logger, release *helmRelease, obj unstructured.Unstructured, rule *ConfigRule) (bool, error) {
+	if obj.GetAnnotations()[HelmResourcePolicyAnnotation] == HelmResourcePolicyKeep {
+		logger.Info("object is kept by helm resource policy", slog.String("object", obj.GetKind()+"/"+obj.GetName()))
+		return false, nil
+	}
+
+	resourceConfig, namespaced, err := j.kubeLookupResourceByKind(ctx, obj.GetAPIVersion(), obj.GetKind())
+	if err != nil {
+		return false, err
+	} else if resourceConfig == nil {
+		logger.Warn("kind of object is not available, skipping", slog.String("object", obj.GetAPIVersion()+"/"+obj.GetKind()+"/"+obj.GetName()))
+		return false, nil
+	}
+
+	namespace := KubeNoNamespace
+	if namespaced {
+		namespace = obj.GetNamespace()
+		if namespace == "" {
+			namespace = release.Namespace
+		}
+	}
+
+	if namespace != KubeNoNamespace {
+		obj.SetNamespace(namespace)
+	}
+
+	// audit the object from the cluster (uid, resourceVersion), not the rendered manifest
+	current, err := j.dynClient.Resource(resourceConfig.AsGVR()).Namespace(namespace).Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if exclusion := j.exclusions.find(rule.Id, current); exclusion != nil {
+		logger.Info("object is excluded", slog.String("object", obj.GetKind()+"/"+obj.GetName()), slog.String("exclusion", exclusion.Id))
+		return false, nil
+	}
+
+	deleted, err := j.kubeDeleteIfExists(ctx, resourceConfig.AsGVR(), namespace, obj.GetName(), rule)
+	if deleted || err != nil {
+		j.audit(rule.Id, ActionTypeDelete, *current, "", nil, deleted, err)
+	}
+	return deleted, err
+}
+
+// kubeDeleteIfExists deletes a resource, returns false if the resource doesn't exist (anymore)
+func (j *Janitor) kubeDeleteIfExists(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string, rule *ConfigRule) (bool, error) {
+	err := j.traceDelete(ctx, gvr, namespace, name, func(ctx context.Context) error {
+		return j.dynClient.Resource(gvr).Namespace(namespace).Delete(ctx, name, rule.DeleteOptions.AsDeleteOptions())
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
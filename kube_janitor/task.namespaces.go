@@ -0,0 +1,464 @@
+package kube_janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	NamespaceTeardownDefaultStuckAfter = 1 * time.Hour
+)
+
+var (
+	errNamespaceNotEmpty = errors.New("namespace is not empty")
+
+	// namespaces which are never touched by namespace rules
+	namespaceProtectedList = []string{
+		"default",
+		"kube-system",
+		"kube-public",
+		"kube-node-lease",
+	}
+)
+
+// runNamespaces executes the namespace rules from the configuration file
+func (j *Janitor) runNamespaces(ctx context.Context) error {
+	metricNamespaceExpiry := prometheusCommon.NewMetricsList()
+	metricNamespaceTerminating := prometheusCommon.NewMetricsList()
+
+	for _, rule := range j.config.Namespaces {
+		err := j.runNamespaceRule(ctx, j.logger, rule, metricNamespaceExpiry, metricNamespaceTerminating)
+		if err != nil {
+			return err
+		}
+	}
+
+	j.prometheus.namespaceExpiry.Reset()
+	metricNamespaceExpiry.GaugeSet(j.prometheus.namespaceExpiry)
+
+	j.prometheus.namespaceTerminating.Reset()
+	metricNamespaceTerminating.GaugeSet(j.prometheus.namespaceTerminating)
+
+	return nil
+}
+
+// runNamespaceRule executes one ConfigNamespaceRule run
+func (j *Janitor) runNamespaceRule(ctx context.Context, logger *slogger.Logger, rule *ConfigNamespaceRule, metricExpiry, metricTerminating *prometheusCommon.MetricList) error {
+	startTime := time.Now()
+	ruleLogger := logger.With(
+		slog.String("rule", rule.String()),
+	)
+	ruleLogger.Info(`starting namespace rule`)
+
+	err := j.kubeEachNamespace(ctx, rule.NamespaceSelector, func(namespace corev1.Namespace) error {
+		namespaceLogger := ruleLogger.With(slog.String("namespace", namespace.Name))
+
+		if slices.Contains(namespaceProtectedList, namespace.Name) {
+			namespaceLogger.Debug("namespace is protected, skipping")
+			return nil
+		}
+
+		if j.isResourceKept(&namespace) {
+			namespaceLogger.Debug("namespace is protected by keep annotation, skipping")
+			return nil
+		}
+
+		if j.isResourceExcluded(namespaceLogger, rule.Id, "/v1/Namespace", &namespace) {
+			return nil
+		}
+
+		// namespace is already terminating, check if it is stuck
+		if namespace.DeletionTimestamp != nil {
+			j.checkNamespaceTerminating(ctx, namespaceLogger, rule, namespace, metricTerminating)
+			return nil
+		}
+
+		// use creation timestamp by default
+		// use the timestamp since when the namespace is empty for empty namespace rules
+		timestamp := namespace.CreationTimestamp.Time
+		if rule.Empty != nil {
+			empty, err := j.checkNamespaceIsEmpty(ctx, rule.Empty, namespace)
+			if err != nil {
+				namespaceLogger.Error("unable to check if namespace is empty", slog.Any("error", err))
+				return nil
+			}
+
+			emptySince := j.conditionSince("namespace.empty."+string(namespace.UID), empty)
+			if emptySince == nil {
+				namespaceLogger.Debug("namespace is not empty")
+				return nil
+			}
+
+			namespaceLogger.Debug("namespace is empty", slog.Time("since", *emptySince))
+			timestamp = *emptySince
+		}
+
+		parsedDate, expired, err := j.checkExpiryDate(timestamp, rule.Ttl, nil)
+		if err != nil {
+			namespaceLogger.Error("unable to parse expiration date", slog.String("raw", rule.Ttl), slog.Any("error", err))
+			return nil
+		}
+
+		j.report.addChecked(rule.Id, expired)
+
+		if !expired {
+			metricExpiry.AddTime(
+				prometheus.Labels{
+					"rule":      rule.Id,
+					"namespace": namespace.Name,
+					"ttl":       rule.Ttl,
+				},
+				*parsedDate,
+			)
+			return nil
+		}
+
+		// errors only affect this namespace, continue with the next one
+		err = j.teardownNamespace(ctx, namespaceLogger, rule, namespace, *parsedDate)
+		if err != nil {
+			namespaceLogger.Error("failed to teardown namespace", slog.Any("error", err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(time.Since(startTime).Seconds())
+	ruleLogger.Info("finished namespace rule", slog.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+// teardownNamespace deletes the resources of an expired namespace step by step and finally the namespace itself.
+// if a step still has remaining resources the teardown stops and continues with the next run.
+func (j *Janitor) teardownNamespace(ctx context.Context, logger *slogger.Logger, rule *ConfigNamespaceRule, namespace corev1.Namespace, expirationDate time.Time) error {
+	// resources protected by the keep annotation or exclude rules protect the whole namespace (deleting the namespace deletes them too)
+	kept, err := j.findNamespaceKeptResource(ctx, rule, namespace)
+	if err != nil {
+		return err
+	} else if kept != "" {
+		logger.Info("namespace is expired but contains protected or excluded resources, skipping teardown", slog.String("resource", kept))
+		return nil
+	}
+
+	logger.Info("namespace is expired, starting teardown", slog.Time("expirationDate", expirationDate))
+
+	for _, step := range rule.Teardown.Steps() {
+		stepLogger := logger.With(slog.String("step", step.Name))
+
+		remaining, err := j.teardownNamespaceStep(ctx, stepLogger, rule, namespace, step)
+		if err != nil {
+			return err
+		}
+
+		if remaining > 0 && !j.dryRun {
+			stepLogger.Info("waiting for teardown step to finish", slog.Int("remaining", remaining))
+			return nil
+		}
+	}
+
+	reason := "TimeToLiveExpired"
+	if rule.Empty != nil {
+		reason = "NamespaceEmptyExpired"
+	}
+
+	// namespace as resource for the run report
+	namespaceResource := unstructured.Unstructured{}
+	namespaceResource.SetAPIVersion("v1")
+	namespaceResource.SetKind("Namespace")
+	namespaceResource.SetName(namespace.Name)
+	namespaceResource.SetUID(namespace.UID)
+	namespaceResource.SetResourceVersion(namespace.ResourceVersion)
+
+	if j.dryRun {
+		logger.Info("namespace teardown finished, would delete namespace (DRY-RUN)")
+		j.report.addResource(rule.Id, namespaceResource, ActionTypeDelete, rule.Ttl, expirationDate, reason, true, nil)
+		j.audit(rule.Id, ActionTypeDelete, namespaceResource, rule.Ttl, &expirationDate, false, nil)
+		return nil
+	}
+
+	logger.Info("namespace teardown finished, deleting namespace")
+	deleteOpts := rule.DeleteOptions.AsDeleteOptions()
+	err = j.traceDelete(ctx, schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, KubeNoNamespace, namespace.Name, func(ctx context.Context) error {
+		return j.kubeClient.CoreV1().Namespaces().Delete(ctx, namespace.Name, deleteOpts)
+	})
+	j.report.addResource(rule.Id, namespaceResource, ActionTypeDelete, rule.Ttl, expirationDate, reason, false, err)
+	j.audit(rule.Id, ActionTypeDelete, namespaceResource, rule.Ttl, &expirationDate, err == nil, err)
+	if err != nil {
+		j.prometheus.actionFailed.With(
+			prometheus.Labels{
+				"rule":             rule.Id,
+				"groupVersionKind": "/v1/Namespace",
+				"namespace":        namespace.Name,
+				"action":           ActionTypeDelete,
+			},
+		).Inc()
+		return err
+	}
+
+	j.prometheus.deleted.With(
+		prometheus.Labels{
+			"rule":             rule.Id,
+			"groupVersionKind": "/v1/Namespace",
+			"namespace":        namespace.Name,
+		},
+	).Inc()
+
+	message := fmt.Sprintf(`TTL of "%v" is expired and namespace is being deleted (%s)`, rule.Ttl, rule.Id)
+	if rule.Empty != nil {
+		message = fmt.Sprintf(`namespace is empty for longer than "%v" and is being deleted (%s)`, rule.Ttl, rule.Id)
+	}
+	err = j.kubeCreateEventFromNamespace(ctx, namespace, KubeEventTypeNormal, KubeEventActionDeleted, message, reason)
+	if err != nil {
+		logger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+	}
+
+	return nil
+}
+
+// findNamespaceKeptResource returns the first resource of the teardown steps which is protected by the keep annotation
+// or excluded from the rule (as kind/name), returns an empty string if no resource is kept
+func (j *Janitor) findNamespaceKeptResource(ctx context.Context, rule *ConfigNamespaceRule, namespace corev1.Namespace) (string, error) {
+	kept := ""
+	for _, step := range rule.Teardown.Steps() {
+		resourceList, err := j.kubeLookupGvkList(ctx, step.Resources, true)
+		if err != nil {
+			return "", err
+		}
+
+		for _, resourceType := range resourceList {
+			err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace.Name, resourceType.Selector, resourceType.FieldSelector, func(resource unstructured.Unstructured) error {
+				if kept == "" && (j.isResourceKept(&resource) || j.exclusions.find(rule.Id, &resource) != nil) {
+					kept = resource.GetKind() + "/" + resource.GetName()
+				}
+				return nil
+			})
+			if err != nil {
+				return "", err
+			}
+
+			if kept != "" {
+				return kept, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// teardownNamespaceStep deletes all resources of one teardown step and returns the count of still existing resources
+func (j *Janitor) teardownNamespaceStep(ctx context.Context, logger *slogger.Logger, rule *ConfigNamespaceRule, namespace corev1.Namespace, step *ConfigNamespaceTeardownStep) (int, error) {
+	remaining := 0
+
+	resourceList, err := j.kubeLookupGvkList(ctx, step.Resources, true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, resourceType := range resourceList {
+		gvkLogger := logger.With(slog.String("groupVersionKind", resourceType.String()))
+
+		err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace.Name, resourceType.Selector, resourceType.FieldSelector, func(resource unstructured.Unstructured) error {
+			remaining++
+
+			// already being deleted
+			if resource.GetDeletionTimestamp() != nil {
+				return nil
+			}
+
+			resourceLogger := gvkLogger.WithGroup("resource").With(slog.String("name", resource.GetName()))
+
+			// kept resources are never deleted, they stop the teardown as remaining resources
+			if j.isResourceKept(&resource) {
+				resourceLogger.Info("resource is protected by keep annotation, stopping teardown")
+				return nil
+			}
+
+			if exclusion := j.exclusions.find(rule.Id, &resource); exclusion != nil {
+				resourceLogger.Info("resource is excluded, stopping teardown", slog.String("exclusion", exclusion.Id))
+				return nil
+			}
+
+			if j.dryRun {
+				resourceLogger.Info("would delete resource for namespace teardown (DRY-RUN)")
+				j.audit(rule.Id, ActionTypeDelete, resource, "", nil, false, nil)
+				return nil
+			}
+
+			resourceLogger.Info("deleting resource for namespace teardown")
+			deleteOpts := rule.DeleteOptions.AsDeleteOptions()
+			err := j.traceDelete(ctx, resourceType.AsGVR(), namespace.Name, resource.GetName(), func(ctx context.Context) error {
+				return j.dynClient.Resource(resourceType.AsGVR()).Namespace(namespace.Name).Delete(ctx, resource.GetName(), deleteOpts)
+			})
+			j.audit(rule.Id, ActionTypeDelete, resource, "", nil, err == nil, err)
+			if err != nil {
+				return err
+			}
+
+			groupVersionKind := resource.GroupVersionKind()
+			j.prometheus.deleted.With(
+				prometheus.Labels{
+					"rule":             rule.Id,
+					"groupVersionKind": fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind),
+					"namespace":        namespace.Name,
+				},
+			).Inc()
+
+			return nil
+		})
+		if err != nil {
+			gvkLogger.Error("failed to teardown resources", slog.Any("error", err))
+			remaining++
+		}
+	}
+
+	return remaining, nil
+}
+
+// checkNamespaceIsEmpty checks if the namespace contains no resources except the ones from the ignore list
+func (j *Janitor) checkNamespaceIsEmpty(ctx context.Context, config *ConfigNamespaceEmpty, namespace corev1.Namespace) (bool, error) {
+	discovery, err := j.kubeDiscover(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// better safe than sorry, resources of undiscovered api groups cannot be checked
+	if len(discovery.failedGroups) > 0 {
+		j.logger.Debug(
+			"partial api discovery, treating namespace as not empty",
+			slog.String("namespace", namespace.Name),
+			slog.Any("failedGroups", discovery.failedGroups),
+		)
+		return false, nil
+	}
+
+	ignoreList := config.IgnoreList()
+
+	for _, serverGroupVersionKind := range discovery.gvkList {
+		// every resource is served by all versions, check only the preferred one
+		if !serverGroupVersionKind.Namespaced || !serverGroupVersionKind.Preferred {
+			continue
+		}
+
+		gvr := schema.GroupVersionResource{
+			Group:    serverGroupVersionKind.Group,
+			Version:  serverGroupVersionKind.Version,
+			Resource: serverGroupVersionKind.Kind,
+		}
+
+		err := j.kubeEachResource(ctx, gvr, namespace.Name, ConfigLabelSelector{}, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+			for _, ignore := range ignoreList {
+				if ignore.Matches(serverGroupVersionKind, resource.GetName()) {
+					return nil
+				}
+			}
+
+			return errNamespaceNotEmpty
+		})
+		if errors.Is(err, errNamespaceNotEmpty) {
+			return false, nil
+		} else if err != nil {
+			// better safe than sorry, treat namespace as not empty if resources cannot be listed
+			j.logger.Debug(
+				"unable to list resources for empty namespace check",
+				slog.String("namespace", namespace.Name),
+				slog.String("groupVersionKind", gvr.String()),
+				slog.Any("error", err),
+			)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// checkNamespaceTerminating checks if a terminating namespace is stuck and reports it via metrics and events
+func (j *Janitor) checkNamespaceTerminating(ctx context.Context, logger *slogger.Logger, rule *ConfigNamespaceRule, namespace corev1.Namespace, metricTerminating *prometheusCommon.MetricList) {
+	terminatingSince := namespace.DeletionTimestamp.Time
+	terminatingDuration := time.Since(terminatingSince)
+	stuck := terminatingDuration > rule.Teardown.stuckAfter
+
+	metricTerminating.AddTime(
+		prometheus.Labels{
+			"rule":      rule.Id,
+			"namespace": namespace.Name,
+			"stuck":     boolToString(stuck),
+		},
+		terminatingSince,
+	)
+
+	if !stuck {
+		logger.Debug("namespace is terminating", slog.Duration("duration", terminatingDuration))
+		return
+	}
+
+	message := fmt.Sprintf(`namespace is terminating since %v (%s)`, terminatingDuration.Round(time.Second), rule.Id)
+
+	var finalizers []string
+	if rule.Teardown.ListFinalizers {
+		finalizers = namespaceBlockingFinalizers(namespace)
+		if len(finalizers) > 0 {
+			message += fmt.Sprintf(`, blocked by: %s`, strings.Join(finalizers, "; "))
+		}
+	}
+
+	logger.Warn("namespace is stuck in terminating", slog.Duration("duration", terminatingDuration), slog.Any("finalizers", finalizers))
+
+	// the Event is only created once per namespace (tracked by the state backend, refreshed while the namespace is stuck)
+	stateKey := "namespace.stuck." + string(namespace.UID)
+	if reportedAt, reported := j.conditionGet(stateKey); reported {
+		j.conditionSet(stateKey, reportedAt)
+		return
+	}
+
+	err := j.kubeCreateEventFromNamespace(ctx, namespace, KubeEventTypeWarning, KubeEventActionTerminating, message, "NamespaceTerminationStuck")
+	if err != nil {
+		logger.Error("unable to create Kubernetes Event", slog.Any("error", err))
+		return
+	}
+	j.conditionSet(stateKey, time.Now())
+}
+
+// namespaceBlockingFinalizers returns the finalizers and remaining content which are blocking the namespace deletion
+func namespaceBlockingFinalizers(namespace corev1.Namespace) []string {
+	ret := []string{}
+
+	for _, finalizer := range namespace.Spec.Finalizers {
+		ret = append(ret, string(finalizer))
+	}
+
+	for _, condition := range namespace.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
+		case corev1.NamespaceFinalizersRemaining, corev1.NamespaceContentRemaining, corev1.NamespaceDeletionContentFailure:
+			ret = append(ret, condition.Message)
+		}
+	}
+
+	return ret
+}
+
+// boolToString converts a bool to a metric label value
+func boolToString(val bool) string {
+	if val {
+		return "true"
+	}
+	return "false"
+}
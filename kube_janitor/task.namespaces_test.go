@@ -0,0 +1,118 @@
+package kube_janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kubeFake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceTeardownKeep(t *testing.T) {
+	keep := map[string]string{AnnotationDefaultKeep: "true"}
+	exclude := []*ConfigExcludeRule{{Id: "KeepPreview", Rules: []string{"CleanupPreviewNamespaces"}}}
+
+	testCases := []struct {
+		name                 string
+		namespaceAnnotations map[string]string
+		claimAnnotations     map[string]string
+		exclusions           map[types.UID][]*ConfigExcludeRule
+		wantDeleted          bool
+	}{
+		{
+			name:        "expired namespace is torn down",
+			wantDeleted: true,
+		},
+		{
+			name:                 "kept namespace",
+			namespaceAnnotations: keep,
+			wantDeleted:          false,
+		},
+		{
+			name:             "kept resource stops teardown",
+			claimAnnotations: keep,
+			wantDeleted:      false,
+		},
+		{
+			name:        "excluded namespace",
+			exclusions:  map[types.UID][]*ConfigExcludeRule{"5e6f7a8b": exclude},
+			wantDeleted: false,
+		},
+		{
+			name:        "excluded resource stops teardown",
+			exclusions:  map[types.UID][]*ConfigExcludeRule{"9c0d1e2f": exclude},
+			wantDeleted: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			deployment := &unstructured.Unstructured{}
+			deployment.SetAPIVersion("apps/v1")
+			deployment.SetKind("Deployment")
+			deployment.SetNamespace("preview")
+			deployment.SetName("app")
+
+			claim := &unstructured.Unstructured{}
+			claim.SetAPIVersion("v1")
+			claim.SetKind("PersistentVolumeClaim")
+			claim.SetNamespace("preview")
+			claim.SetName("data")
+			claim.SetUID("9c0d1e2f")
+			claim.SetAnnotations(testCase.claimAnnotations)
+
+			j := newTestJanitor(t, deployment, claim)
+			j.kubeClient = kubeFake.NewClientset(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "preview",
+					UID:               "5e6f7a8b",
+					Annotations:       testCase.namespaceAnnotations,
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-48 * time.Hour)),
+				},
+			})
+			if testCase.exclusions != nil {
+				j.exclusions = &exclusionSet{resources: testCase.exclusions}
+			}
+
+			rule := &ConfigNamespaceRule{
+				Id:  "CleanupPreviewNamespaces",
+				Ttl: "1d",
+				Teardown: ConfigNamespaceTeardown{
+					Order: []*ConfigNamespaceTeardownStep{
+						{Name: "workloads", Resources: ConfigResourceList{{Group: "apps", Version: "v1", Kind: "deployments"}}},
+						{Name: "volumes", Resources: ConfigResourceList{{Group: "", Version: "v1", Kind: "persistentvolumeclaims"}}},
+					},
+				},
+			}
+			if err := rule.Validate(); err != nil {
+				t.Fatalf("namespace rule validation failed: %v", err)
+			}
+
+			// one run per teardown step and one for the namespace
+			for range 3 {
+				if err := j.runNamespaceRule(ctx, j.logger, rule, prometheusCommon.NewMetricsList(), prometheusCommon.NewMetricsList()); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			_, err := j.kubeClient.CoreV1().Namespaces().Get(ctx, "preview", metav1.GetOptions{})
+			if deleted := kubeErrors.IsNotFound(err); deleted != testCase.wantDeleted {
+				t.Fatalf("namespace deleted: got %v, want %v", deleted, testCase.wantDeleted)
+			}
+
+			_, err = j.dynClient.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Namespace("preview").Get(ctx, "app", metav1.GetOptions{})
+			if deleted := kubeErrors.IsNotFound(err); deleted != testCase.wantDeleted {
+				t.Fatalf("deployment deleted: got %v, want %v", deleted, testCase.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type (
+	// orphanReferenceSet contains all referenced configmaps and secrets (by "<kind>/<name>") of one namespace
+	orphanReferenceSet map[string]bool
+
+	// orphanReferrer defines a resource type which can reference configmaps and secrets
+	orphanReferrer struct {
+		gvr         schema.GroupVersionResource
+		podSpecPath []string
+	}
+)
+
+var (
+	// well-known names which are never treated as orphaned
+	orphanAllowList = []string{
+		"kube-root-ca.crt",
+		"openshift-service-ca.crt",
+		"istio-ca-root-cert",
+		"default-token-*",
+		"sh.helm.release.v1.*",
+	}
+
+	// resources which can reference configmaps and secrets via their pod spec
+	orphanReferrerList = []orphanReferrer{
+		{gvr: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}, podSpecPath: []string{"spec"}},
+		{gvr: schema.GroupVersionResource{Group: "", Version: "v1", Resource: "podtemplates"}, podSpecPath: []string{"template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, podSpecPath: []string{"spec", "template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, podSpecPath: []string{"spec", "template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, podSpecPath: []string{"spec", "template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, podSpecPath: []string{"spec", "template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, podSpecPath: []string{"spec", "template", "spec"}},
+		{gvr: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, podSpecPath: []string{"spec", "jobTemplate", "spec", "template", "spec"}},
+	}
+
+	orphanServiceAccountGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "serviceaccounts"}
+	orphanIngressGVR        = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+)
+
+// runOrphans executes the orphan rules from the configuration file
+func (j *Janitor) runOrphans(ctx context.Context) error {
+	metricResourceRule := prometheusCommon.NewMetricsList()
+
+	for _, rule := range j.config.Orphans {
+		err := j.runOrphanRule(ctx, j.logger, rule, metricResourceRule)
+		if err != nil {
+			return err
+		}
+	}
+
+	metricResourceRule.GaugeSet(j.prometheus.rule)
+
+	return nil
+}
+
+// runOrphanRule executes one ConfigOrphanRule run
+func (j *Janitor) runOrphanRule(ctx context.Context, logger *slogger.Logger, orphanRule *ConfigOrphanRule, metricList *prometheusCommon.MetricList) error {
+	startTime := time.Now()
+	ruleLogger := logger.With(
+		slog.String("rule", orphanRule.String()),
+	)
+	ruleLogger.Info(`starting orphan rule`)
+
+	// faked rule for orphan handling
+	rule := &ConfigRule{
+		Id:                orphanRule.Id,
+		Resources:         orphanRule.ResourceList(),
+		NamespaceSelector: orphanRule.NamespaceSelector,
+		Ttl:               orphanRule.Ttl,
+		DeleteOptions:     orphanRule.DeleteOptions,
+	}
+
+	err := j.kubeEachNamespace(ctx, rule.NamespaceSelector, func(namespace corev1.Namespace) error {
+		namespaceLogger := ruleLogger.With(slog.String("namespace", namespace.Name))
+
+		references, err := j.kubeFetchOrphanReferences(ctx, namespace.Name)
+		if err != nil {
+			namespaceLogger.Error("failed to fetch references, skipping namespace", slog.Any("error", err))
+			return nil
+		}
+
+		for _, resourceType := range rule.Resources {
+			gvkLogger := namespaceLogger.With(slog.String("groupVersionKind", resourceType.String()))
+
+			err := j.kubeEachResource(ctx, resourceType.AsGVR(), namespace.Name, resourceType.Selector, resourceType.FieldSelector, func(resource unstructured.Unstructured) error {
+				groupVersionKind := resource.GroupVersionKind()
+				gvkLabel := fmt.Sprintf("%s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind)
+				j.prometheus.resourceScanned.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel}).Inc()
+
+				resourceLogger := gvkLogger.WithGroup("resource").With(
+					slog.String("namespace", resource.GetNamespace()),
+					slog.String("name", resource.GetName()),
+					slog.String("ttl", rule.Ttl),
+				)
+
+				if orphanRule.isAllowed(resource) {
+					return nil
+				}
+
+				if !resourceType.FilterPath.IsEmpty() {
+					skipped, err := j.checkResourceIsSkippedFromJmesPath(resource, resourceType.FilterPath)
+					if err != nil {
+						return err
+					}
+
+					if skipped {
+						resourceLogger.Debug("resource skipped by JMES path")
+						j.prometheus.resourceSkipped.With(prometheus.Labels{"rule": rule.Id, "groupVersionKind": gvkLabel, "reason": MetricSkipReasonFilterPath}).Inc()
+						return nil
+					}
+				}
+
+				if j.isResourceExcluded(resourceLogger, rule.Id, gvkLabel, &resource) {
+					return nil
+				}
+
+				referenced := references[fmt.Sprintf("%s/%s", resourceType.Kind, resource.GetName())]
+				orphanSince := j.conditionSince("orphan."+string(resource.GetUID()), !referenced)
+				if orphanSince == nil {
+					return nil
+				}
+
+				resourceLogger.Debug("resource is not referenced", slog.Time("since", *orphanSince))
+				return j.checkResourceExpiryAndTriggerDelete(ctx, resourceLogger, resourceType, resource, rule, rule.Ttl, *orphanSince, metricList)
+			})
+			if err != nil {
+				gvkLogger.Error("failed to list resources", slog.Any("error", err))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(time.Since(startTime).Seconds())
+	ruleLogger.Info("finished orphan rule", slog.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+// isAllowed checks if the resource is allowed to exist without references (well-known names, allow list and managed resources)
+func (c *ConfigOrphanRule) isAllowed(resource unstructured.Unstructured) bool {
+	// resources of the janitor itself (eg. state and report ConfigMaps)
+	if resource.GetLabels()[KubeLabelManagedBy] == KubeLabelManagedByValue {
+		return true
+	}
+
+	// owned resources are managed by their owner
+	if len(resource.GetOwnerReferences()) > 0 {
+		return true
+	}
+
+	// service account tokens are managed by Kubernetes
+	if resource.GetKind() == "Secret" {
+		if secretType, _, _ := unstructured.NestedString(resource.Object, "type"); secretType == string(corev1.SecretTypeServiceAccountToken) {
+			return true
+		}
+	}
+
+	for _, list := range [][]string{orphanAllowList, c.Allow} {
+		for _, pattern := range list {
+			if matched, _ := path.Match(pattern, resource.GetName()); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// kubeFetchOrphanReferences collects all configmaps and secrets which are referenced inside a namespace
+func (j *Janitor) kubeFetchOrphanReferences(ctx context.Context, namespace string) (orphanReferenceSet, error) {
+	references := orphanReferenceSet{}
+
+	// pods and pod templates
+	for _, referrer := range orphanReferrerList {
+		err := j.kubeEachResource(ctx, referrer.gvr, namespace, ConfigLabelSelector{}, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+			podSpecRaw, exists, err := unstructured.NestedMap(resource.Object, referrer.podSpecPath...)
+			if err != nil || !exists {
+				return err
+			}
+
+			podSpec := corev1.PodSpec{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(podSpecRaw, &podSpec); err != nil {
+				return err
+			}
+
+			references.addPodSpec(podSpec)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// service accounts (secrets and imagePullSecrets)
+	err := j.kubeEachResource(ctx, orphanServiceAccountGVR, namespace, ConfigLabelSelector{}, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+		serviceAccount := corev1.ServiceAccount{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, &serviceAccount); err != nil {
+			return err
+		}
+
+		for _, secret := range serviceAccount.Secrets {
+			references.add("secrets", secret.Name)
+		}
+		for _, secret := range serviceAccount.ImagePullSecrets {
+			references.add("secrets", secret.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ingresses (tls secrets)
+	err = j.kubeEachResource(ctx, orphanIngressGVR, namespace, ConfigLabelSelector{}, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+		tlsList, _, err := unstructured.NestedSlice(resource.Object, "spec", "tls")
+		if err != nil {
+			return err
+		}
+
+		for _, tls := range tlsList {
+			if tlsMap, ok := tls.(map[string]interface{}); ok {
+				if secretName, ok := tlsMap["secretName"].(string); ok {
+					references.add("secrets", secretName)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return references, nil
+}
+
+// add adds a reference to the set
+func (r orphanReferenceSet) add(kind, name string) {
+	if name != "" {
+		r[fmt.Sprintf("%s/%s", kind, name)] = true
+	}
+}
+
+// addPodSpec adds all configmap and secret references (volumes, envFrom, env valueFrom and imagePullSecrets) of a pod spec to the set
+func (r orphanReferenceSet) addPodSpec(podSpec corev1.PodSpec) {
+	for _, secret := range podSpec.ImagePullSecrets {
+		r.add("secrets", secret.Name)
+	}
+
+	for _, volume := range podSpec.Volumes {
+		if volume.ConfigMap != nil {
+			r.add("configmaps", volume.ConfigMap.Name)
+		}
+
+		if volume.Secret != nil {
+			r.add("secrets", volume.Secret.SecretName)
+		}
+
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					r.add("configmaps", source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					r.add("secrets", source.Secret.Name)
+				}
+			}
+		}
+	}
+
+	containers := []corev1.Container{}
+	containers = append(containers, podSpec.InitContainers...)
+	containers = append(containers, podSpec.Containers...)
+	for _, container := range podSpec.EphemeralContainers {
+		containers = append(containers, corev1.Container(container.EphemeralContainerCommon))
+	}
+
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				r.add("configmaps", envFrom.ConfigMapRef.Name)
+			}
+			if envFrom.SecretRef != nil {
+				r.add("secrets", envFrom.SecretRef.Name)
+			}
+		}
+
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				r.add("configmaps", env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				r.add("secrets", env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+}
@@ -12,13 +12,42 @@ func (j *Janitor) runRules(ctx context.Context) error {
 	metricResourceRule := prometheusCommon.NewMetricsList()
 
 	filterFunc := func(rule *ConfigRule, resource unstructured.Unstructured) (string, bool) {
+		// with conditionFor the ttl is measured from the first match of the resource and defaults to conditionFor
+		// (see checkResourceTtlAndTriggerDeleteIfExpired)
+		if rule.Ttl == "" && rule.ConditionFor != "" {
+			return rule.ConditionFor, true
+		}
+
 		return rule.Ttl, true
 	}
 
-	for _, rule := range j.config.Rules {
-		err := j.runRule(ctx, j.logger, rule, metricResourceRule, filterFunc)
-		if err != nil {
-			return err
+	rules := sortRulesByPriority(j.config.Rules)
+	j.ruleOverruled = nil
+
+	switch j.config.OverlapPolicy {
+	case "", OverlapPolicyAll:
+		// every rule evaluates the resources independently
+		for _, rule := range rules {
+			err := j.runRule(ctx, j.logger, rule, metricResourceRule, filterFunc)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		// match all rules first, resources matched by multiple rules are only processed by the winning rule
+		matches := make([]*ruleMatch, len(rules))
+		for i, rule := range rules {
+			match, err := j.matchRule(ctx, j.logger, rule, filterFunc)
+			if err != nil {
+				return err
+			}
+			matches[i] = match
+		}
+
+		j.resolveRuleOverlaps(rules, matches)
+
+		for i, rule := range rules {
+			j.processRule(ctx, j.logger, rule, matches[i], metricResourceRule)
 		}
 	}
 
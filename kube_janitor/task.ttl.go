@@ -48,6 +48,12 @@ func (j *Janitor) runTtlResources(ctx context.Context) error {
 	rule := &ConfigRule{
 		Id:            RuleIdInternalTTL,
 		Resources:     j.config.Ttl.Resources,
+		MaxLifetime:   j.config.Ttl.MaxLifetime,
+		maxLifetime:   j.config.Ttl.maxLifetime,
+		Approval:      j.config.Ttl.Approval,
+		Notification:  j.config.Ttl.Notification,
+		Owners:        j.config.Ttl.Owners,
+		Finalizers:    j.config.Ttl.Finalizers,
 		DeleteOptions: j.config.Ttl.DeleteOptions,
 	}
 
@@ -0,0 +1,303 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	VolumeRuleTypeUnusedClaim    = "UnusedClaim"
+	VolumeRuleTypeReleasedVolume = "ReleasedVolume"
+
+	VolumeSnapshotDefaultTimeout  = 5 * time.Minute
+	VolumeSnapshotAnnotationClaim = "janitor/source-persistentvolumeclaim"
+)
+
+var (
+	volumeClaimResource = &ConfigResource{Group: "", Version: "v1", Kind: "persistentvolumeclaims"}
+	volumeResource      = &ConfigResource{Group: "", Version: "v1", Kind: "persistentvolumes"}
+
+	volumeSnapshotGVR = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+	volumePodGVR      = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+)
+
+// runVolumes executes the volume rules from the configuration file
+func (j *Janitor) runVolumes(ctx context.Context) error {
+	metricResourceRule := prometheusCommon.NewMetricsList()
+
+	for _, rule := range j.config.Volumes {
+		var err error
+
+		switch rule.Type {
+		case VolumeRuleTypeUnusedClaim:
+			err = j.runVolumeUnusedClaimRule(ctx, j.logger, rule, metricResourceRule)
+		case VolumeRuleTypeReleasedVolume:
+			err = j.runVolumeReleasedRule(ctx, j.logger, rule, metricResourceRule)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	metricResourceRule.GaugeSet(j.prometheus.rule)
+
+	return nil
+}
+
+// runVolumeUnusedClaimRule deletes PersistentVolumeClaims which are not used by any Pod for longer than the ttl
+func (j *Janitor) runVolumeUnusedClaimRule(ctx context.Context, logger *slogger.Logger, volumeRule *ConfigVolumeRule, metricList *prometheusCommon.MetricList) error {
+	startTime := time.Now()
+	ruleLogger := logger.With(
+		slog.String("rule", volumeRule.String()),
+	)
+	ruleLogger.Info(`starting volume rule`)
+
+	rule := volumeRule.asConfigRule()
+
+	err := j.kubeEachNamespace(ctx, volumeRule.NamespaceSelector, func(namespace corev1.Namespace) error {
+		namespaceLogger := ruleLogger.With(slog.String("namespace", namespace.Name))
+
+		usedClaims, err := j.kubeFetchUsedVolumeClaims(ctx, namespace.Name)
+		if err != nil {
+			namespaceLogger.Error("failed to fetch pods, skipping namespace", slog.Any("error", err))
+			return nil
+		}
+
+		err = j.kubeEachResource(ctx, volumeClaimResource.AsGVR(), namespace.Name, volumeRule.Selector, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+			resourceLogger := namespaceLogger.WithGroup("resource").With(
+				slog.String("namespace", resource.GetNamespace()),
+				slog.String("name", resource.GetName()),
+				slog.String("ttl", rule.Ttl),
+			)
+
+			if resource.GetDeletionTimestamp() != nil {
+				return nil
+			}
+
+			if j.isResourceExcluded(resourceLogger, rule.Id, "/v1/PersistentVolumeClaim", &resource) {
+				return nil
+			}
+
+			unusedSince := j.conditionSince("volume.unused."+string(resource.GetUID()), !usedClaims[resource.GetName()])
+			if unusedSince == nil {
+				return nil
+			}
+
+			resourceLogger.Debug("persistent volume claim is not used by any pod", slog.Time("since", *unusedSince))
+
+			// the snapshot (if configured) is created by the action after all expiry checks (keep, extensions)
+			return j.checkResourceExpiryAndTriggerDelete(ctx, resourceLogger, volumeClaimResource, resource, rule, rule.Ttl, *unusedSince, metricList)
+		})
+		if err != nil {
+			namespaceLogger.Error("failed to list resources", slog.Any("error", err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(time.Since(startTime).Seconds())
+	ruleLogger.Info("finished volume rule", slog.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+// runVolumeReleasedRule deletes PersistentVolumes which are in phase Released for longer than the ttl
+func (j *Janitor) runVolumeReleasedRule(ctx context.Context, logger *slogger.Logger, volumeRule *ConfigVolumeRule, metricList *prometheusCommon.MetricList) error {
+	startTime := time.Now()
+	ruleLogger := logger.With(
+		slog.String("rule", volumeRule.String()),
+	)
+	ruleLogger.Info(`starting volume rule`)
+
+	rule := volumeRule.asConfigRule()
+
+	err := j.kubeEachResource(ctx, volumeResource.AsGVR(), KubeNoNamespace, volumeRule.Selector, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+		resourceLogger := ruleLogger.WithGroup("resource").With(
+			slog.String("name", resource.GetName()),
+			slog.String("ttl", rule.Ttl),
+		)
+
+		if resource.GetDeletionTimestamp() != nil {
+			return nil
+		}
+
+		if j.isResourceExcluded(resourceLogger, rule.Id, "/v1/PersistentVolume", &resource) {
+			return nil
+		}
+
+		// only volumes with reclaimPolicy Retain stay in phase Released,
+		// volumes with reclaimPolicy Delete are removed (or are failing) by the provisioner
+		phase, _, _ := unstructured.NestedString(resource.Object, "status", "phase")
+		reclaimPolicy, _, _ := unstructured.NestedString(resource.Object, "spec", "persistentVolumeReclaimPolicy")
+		released := phase == string(corev1.VolumeReleased) && reclaimPolicy == string(corev1.PersistentVolumeReclaimRetain)
+		releasedSince := j.conditionSince("volume.released."+string(resource.GetUID()), released)
+		if releasedSince == nil {
+			return nil
+		}
+
+		// prefer the phase transition time from Kubernetes (if available)
+		if val, _, _ := unstructured.NestedString(resource.Object, "status", "lastPhaseTransitionTime"); val != "" {
+			if timestamp := j.parseTimestamp(val); timestamp != nil {
+				releasedSince = timestamp
+			}
+		}
+
+		resourceLogger.Debug("persistent volume is released", slog.Time("since", *releasedSince))
+		return j.checkResourceExpiryAndTriggerDelete(ctx, resourceLogger, volumeResource, resource, rule, rule.Ttl, *releasedSince, metricList)
+	})
+	if err != nil {
+		ruleLogger.Error("failed to list resources", slog.Any("error", err))
+	}
+
+	j.prometheus.ruleDuration.With(prometheus.Labels{"rule": rule.Id}).Observe(time.Since(startTime).Seconds())
+	ruleLogger.Info("finished volume rule", slog.Duration("duration", time.Since(startTime)))
+
+	return nil
+}
+
+// asConfigRule creates a faked ConfigRule for the volume rule
+func (c *ConfigVolumeRule) asConfigRule() *ConfigRule {
+	rule := &ConfigRule{
+		Id:                c.Id,
+		NamespaceSelector: c.NamespaceSelector,
+		Ttl:               c.Ttl,
+		DeleteOptions:     c.DeleteOptions,
+	}
+
+	// snapshot before the deletion
+	if c.Snapshot != nil {
+		rule.Action = &ConfigRuleAction{
+			Type:   ActionTypeDelete,
+			action: &janitorActionSnapshotDelete{config: c.Snapshot},
+		}
+	}
+
+	return rule
+}
+
+// kubeFetchUsedVolumeClaims collects all PersistentVolumeClaim names which are used by pods inside a namespace
+func (j *Janitor) kubeFetchUsedVolumeClaims(ctx context.Context, namespace string) (map[string]bool, error) {
+	ret := map[string]bool{}
+
+	err := j.kubeEachResource(ctx, volumePodGVR, namespace, ConfigLabelSelector{}, KubeNoFieldSelector, func(resource unstructured.Unstructured) error {
+		pod := corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, &pod); err != nil {
+			return err
+		}
+
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				ret[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+
+			// generic ephemeral volumes are named <pod>-<volume>
+			if volume.Ephemeral != nil {
+				ret[fmt.Sprintf("%s-%s", pod.Name, volume.Name)] = true
+			}
+		}
+
+		return nil
+	})
+
+	return ret, err
+}
+
+// kubeSnapshotVolumeClaim creates a VolumeSnapshot for the PersistentVolumeClaim (if not exists) and returns if it is ready to use,
+// the snapshot is not awaited, a snapshot which is not ready within the timeout fails
+func (j *Janitor) kubeSnapshotVolumeClaim(ctx context.Context, rule *ConfigRule, config *ConfigVolumeSnapshot, claim unstructured.Unstructured) (bool, error) {
+	snapshotName := volumeSnapshotName(claim)
+	snapshotClient := j.dynClient.Resource(volumeSnapshotGVR).Namespace(claim.GetNamespace())
+	snapshotLogger := j.logger.With(
+		slog.String("namespace", claim.GetNamespace()),
+		slog.String("persistentVolumeClaim", claim.GetName()),
+		slog.String("volumeSnapshot", snapshotName),
+	)
+
+	snapshot, err := snapshotClient.Get(ctx, snapshotName, metav1.GetOptions{})
+	if kubeErrors.IsNotFound(err) {
+		snapshot = &unstructured.Unstructured{}
+		snapshot.SetAPIVersion(volumeSnapshotGVR.GroupVersion().String())
+		snapshot.SetKind("VolumeSnapshot")
+		snapshot.SetName(snapshotName)
+		snapshot.SetNamespace(claim.GetNamespace())
+		snapshot.SetLabels(map[string]string{
+			KubeLabelManagedBy: KubeLabelManagedByValue,
+		})
+		snapshot.SetAnnotations(map[string]string{
+			VolumeSnapshotAnnotationClaim: claim.GetName(),
+		})
+
+		spec := map[string]interface{}{
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": claim.GetName(),
+			},
+		}
+		if config.VolumeSnapshotClassName != "" {
+			spec["volumeSnapshotClassName"] = config.VolumeSnapshotClassName
+		}
+		snapshot.Object["spec"] = spec
+
+		snapshotLogger.Info("creating volume snapshot before deletion")
+		created, err := snapshotClient.Create(ctx, snapshot, metav1.CreateOptions{FieldManager: KubeFieldManager})
+		if err == nil {
+			snapshot = created
+		}
+		j.audit(rule.Id, AuditActionVolumeSnapshot, *snapshot, "", nil, err == nil, err)
+		if err != nil {
+			return false, err
+		}
+
+		// readyToUse is checked by the next run
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if message, exists, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); exists {
+		return false, fmt.Errorf(`volume snapshot "%s" failed: %s`, snapshotName, message)
+	}
+
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); ready {
+		return true, nil
+	}
+
+	if createdAt := snapshot.GetCreationTimestamp(); createdAt.IsZero() {
+		return false, nil
+	} else if waiting := time.Since(createdAt.Time); waiting > config.timeout {
+		return false, fmt.Errorf(`volume snapshot "%s" is not ready to use after %v`, snapshotName, waiting.Round(time.Second))
+	}
+
+	return false, nil
+}
+
+// volumeSnapshotName builds the (stable) name of the VolumeSnapshot for a PersistentVolumeClaim
+func volumeSnapshotName(claim unstructured.Unstructured) string {
+	suffix := string(claim.GetUID())
+	if len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+	suffix = "-janitor-" + suffix
+
+	name := claim.GetName()
+	if maxLength := 253 - len(suffix); len(name) > maxLength {
+		name = name[:maxLength]
+	}
+
+	return name + suffix
+}
@@ -0,0 +1,121 @@
+package kube_janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheusCommon "github.com/webdevops/go-common/prometheus"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestVolumeClaimSnapshotThenDelete(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotations    map[string]string
+		snapshotStatus map[string]interface{}
+		wantSnapshot   bool
+		wantDeleted    bool
+	}{
+		{
+			name:           "snapshot ready, claim deleted",
+			snapshotStatus: map[string]interface{}{"readyToUse": true},
+			wantSnapshot:   true,
+			wantDeleted:    true,
+		},
+		{
+			name:           "snapshot not ready, deletion postponed",
+			snapshotStatus: map[string]interface{}{"readyToUse": false},
+			wantSnapshot:   true,
+			wantDeleted:    false,
+		},
+		{
+			name:           "snapshot failed, claim kept",
+			snapshotStatus: map[string]interface{}{"readyToUse": false, "error": map[string]interface{}{"message": "failed"}},
+			wantSnapshot:   true,
+			wantDeleted:    false,
+		},
+		{
+			name:         "keep annotation, no snapshot",
+			annotations:  map[string]string{AnnotationDefaultKeep: "true"},
+			wantSnapshot: false,
+			wantDeleted:  false,
+		},
+		{
+			name:         "ttl extended, no snapshot",
+			annotations:  map[string]string{AnnotationDefaultTtlExtend: "30d"},
+			wantSnapshot: false,
+			wantDeleted:  false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			claim := &unstructured.Unstructured{}
+			claim.SetAPIVersion("v1")
+			claim.SetKind("PersistentVolumeClaim")
+			claim.SetNamespace("test")
+			claim.SetName("data")
+			claim.SetUID("0a1b2c3d-4e5f")
+			claim.SetAnnotations(testCase.annotations)
+
+			j := newTestJanitor(t, claim)
+
+			volumeRule := &ConfigVolumeRule{
+				Id:       "CleanupUnusedClaims",
+				Type:     VolumeRuleTypeUnusedClaim,
+				Ttl:      "14d",
+				Snapshot: &ConfigVolumeSnapshot{},
+			}
+			if err := volumeRule.Validate(); err != nil {
+				t.Fatalf("volume rule validation failed: %v", err)
+			}
+			rule := volumeRule.asConfigRule()
+
+			claimClient := j.dynClient.Resource(volumeClaimResource.AsGVR()).Namespace("test")
+			snapshotClient := j.dynClient.Resource(volumeSnapshotGVR).Namespace("test")
+			unusedSince := time.Now().Add(-30 * 24 * time.Hour)
+
+			run := func() {
+				resource, err := claimClient.Get(ctx, "data", metav1.GetOptions{})
+				if kubeErrors.IsNotFound(err) {
+					return
+				} else if err != nil {
+					t.Fatalf("unable to get claim: %v", err)
+				}
+
+				// errors of the action are reported by logs and metrics
+				_ = j.checkResourceExpiryAndTriggerDelete(ctx, j.logger, volumeClaimResource, *resource, rule, rule.Ttl, unusedSince, prometheusCommon.NewMetricsList())
+			}
+
+			// first run creates the snapshot, the claim is not deleted until the snapshot is ready
+			run()
+			if _, err := claimClient.Get(ctx, "data", metav1.GetOptions{}); err != nil {
+				t.Fatalf("claim was deleted before the snapshot was ready: %v", err)
+			}
+
+			snapshot, err := snapshotClient.Get(ctx, volumeSnapshotName(*claim), metav1.GetOptions{})
+			if hasSnapshot := err == nil; hasSnapshot != testCase.wantSnapshot {
+				t.Fatalf("snapshot exists: got %v, want %v (%v)", hasSnapshot, testCase.wantSnapshot, err)
+			}
+
+			if snapshot != nil && testCase.snapshotStatus != nil {
+				snapshot.Object["status"] = testCase.snapshotStatus
+				if _, err := snapshotClient.Update(ctx, snapshot, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("unable to update snapshot: %v", err)
+				}
+			}
+
+			// second run deletes the claim if the snapshot is ready
+			run()
+			_, err = claimClient.Get(ctx, "data", metav1.GetOptions{})
+			if deleted := kubeErrors.IsNotFound(err); deleted != testCase.wantDeleted {
+				t.Fatalf("claim deleted: got %v, want %v", deleted, testCase.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package kube_janitor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	TimestampSourceCreationTimestamp = "creationTimestamp"
+	TimestampSourceTimestampPath     = "timestampPath"
+	TimestampSourceManagedFields     = "managedFields"
+	TimestampSourcePods              = "pods"
+	TimestampSourceLastUsed          = "lastUsed"
+)
+
+var (
+	timestampPodGVR = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+)
+
+// fetchResourceTimestamp returns the timestamp which is used for the TTL calculation (based on timestampSource),
+// returns nil if the timestamp could not be found (resource should be skipped)
+func (j *Janitor) fetchResourceTimestamp(ctx context.Context, logger *slogger.Logger, resourceConfig *ConfigResource, resource unstructured.Unstructured) *time.Time {
+	timestamp := resource.GetCreationTimestamp().Time
+
+	timestampSource := resourceConfig.TimestampSource
+	if timestampSource == "" && !resourceConfig.TimestampPath.IsEmpty() {
+		timestampSource = TimestampSourceTimestampPath
+	}
+
+	switch timestampSource {
+	case TimestampSourceTimestampPath:
+		val, err := j.parseResourceTimestampFromJmesPath(resource, resourceConfig.TimestampPath)
+		if err != nil {
+			logger.Warn("parse resource timestamp from jmesPath failed", slog.Any("error", err))
+			return nil
+		} else if val == nil {
+			logger.Debug("parse resource timestamp from jmesPath failed")
+			return nil
+		}
+		timestamp = *val
+	case TimestampSourceManagedFields:
+		if val := resourceTimestampFromManagedFields(resource); val != nil {
+			timestamp = *val
+		}
+	case TimestampSourcePods:
+		val, err := j.fetchResourceTimestampFromPods(ctx, resource)
+		if err != nil {
+			logger.Warn("fetching resource timestamp from pods failed", slog.Any("error", err))
+			return nil
+		}
+
+		if val != nil {
+			timestamp = *val
+		}
+	case TimestampSourceLastUsed:
+		if val, exists := resource.GetAnnotations()[j.config.Annotations.LastUsed]; exists {
+			lastUsed := j.parseTimestamp(val)
+			if lastUsed == nil {
+				logger.Warn("unable to parse last used annotation, using creation timestamp", slog.String("raw", val))
+			} else {
+				timestamp = *lastUsed
+			}
+		}
+	}
+
+	// last activity should never be older than the creation
+	if creationTimestamp := resource.GetCreationTimestamp().Time; timestampSource != TimestampSourceTimestampPath && timestamp.Before(creationTimestamp) {
+		timestamp = creationTimestamp
+	}
+
+	return &timestamp
+}
+
+// resourceTimestampFromManagedFields returns the newest managedFields time (updates of the status subresource
+// and writes of the janitor itself, eg. approval marks or actions, are ignored)
+func resourceTimestampFromManagedFields(resource unstructured.Unstructured) *time.Time {
+	var ret *time.Time
+
+	for _, managedField := range resource.GetManagedFields() {
+		if managedField.Time == nil || managedField.Subresource == "status" || managedField.Manager == KubeFieldManager {
+			continue
+		}
+
+		ret = newestTimestamp(ret, managedField.Time.Time)
+	}
+
+	return ret
+}
+
+// fetchResourceTimestampFromPods returns the newest start/restart time of the pods of a workload (or the pod itself)
+func (j *Janitor) fetchResourceTimestampFromPods(ctx context.Context, resource unstructured.Unstructured) (*time.Time, error) {
+	var ret *time.Time
+
+	switch resource.GetKind() {
+	case "Pod":
+		pod := corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, &pod); err != nil {
+			return nil, err
+		}
+		return podActivityTimestamp(pod), nil
+	case "CronJob":
+		// cronjobs have no pod selector, use the last schedule instead
+		if val, exists, _ := unstructured.NestedString(resource.Object, "status", "lastScheduleTime"); exists {
+			return j.parseTimestamp(val), nil
+		}
+		return nil, nil
+	}
+
+	selectorRaw, exists, err := unstructured.NestedMap(resource.Object, "spec", "selector")
+	if err != nil || !exists {
+		// not a workload with pods
+		return nil, err
+	}
+
+	selector := ConfigLabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selectorRaw, &selector.LabelSelector); err != nil {
+		return nil, err
+	}
+
+	// an empty selector would match all pods in the namespace
+	if selector.IsEmpty() {
+		return nil, nil
+	}
+
+	err = j.kubeEachResource(ctx, timestampPodGVR, resource.GetNamespace(), selector, KubeNoFieldSelector, func(item unstructured.Unstructured) error {
+		pod := corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pod); err != nil {
+			return err
+		}
+
+		if val := podActivityTimestamp(pod); val != nil {
+			ret = newestTimestamp(ret, *val)
+		}
+		return nil
+	})
+
+	return ret, err
+}
+
+// podActivityTimestamp returns the newest start, container start or container restart time of a pod
+func podActivityTimestamp(pod corev1.Pod) *time.Time {
+	var ret *time.Time
+
+	timestamps := []*metav1.Time{pod.Status.StartTime}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Running != nil {
+			timestamps = append(timestamps, &containerStatus.State.Running.StartedAt)
+		}
+		if containerStatus.LastTerminationState.Terminated != nil {
+			timestamps = append(timestamps, &containerStatus.LastTerminationState.Terminated.FinishedAt)
+		}
+	}
+
+	for _, timestamp := range timestamps {
+		if timestamp != nil && !timestamp.IsZero() {
+			ret = newestTimestamp(ret, timestamp.Time)
+		}
+	}
+
+	return ret
+}
+
+// newestTimestamp returns the newer timestamp
+func newestTimestamp(current *time.Time, val time.Time) *time.Time {
+	if current == nil || val.After(*current) {
+		return &val
+	}
+	return current
+}
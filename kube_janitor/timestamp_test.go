@@ -0,0 +1,78 @@
+package kube_janitor
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResourceTimestampFromManagedFields(t *testing.T) {
+	createdAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	managedField := func(manager, subresource string, offset time.Duration) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:     manager,
+			Operation:   metav1.ManagedFieldsOperationUpdate,
+			Subresource: subresource,
+			Time:        &metav1.Time{Time: createdAt.Add(offset)},
+		}
+	}
+
+	timestamp := func(offset time.Duration) *time.Time {
+		val := createdAt.Add(offset)
+		return &val
+	}
+
+	testCases := []struct {
+		name          string
+		managedFields []metav1.ManagedFieldsEntry
+		wantTimestamp *time.Time
+	}{
+		{
+			name: "newest update",
+			managedFields: []metav1.ManagedFieldsEntry{
+				managedField("kubectl", "", 1*time.Hour),
+				managedField("helm", "", 2*time.Hour),
+			},
+			wantTimestamp: timestamp(2 * time.Hour),
+		},
+		{
+			name: "status updates are ignored",
+			managedFields: []metav1.ManagedFieldsEntry{
+				managedField("kubectl", "", 1*time.Hour),
+				managedField("kube-controller-manager", "status", 5*time.Hour),
+			},
+			wantTimestamp: timestamp(1 * time.Hour),
+		},
+		{
+			name: "janitor writes are ignored (approval marks, actions)",
+			managedFields: []metav1.ManagedFieldsEntry{
+				managedField("kubectl", "", 1*time.Hour),
+				managedField(KubeFieldManager, "", 5*time.Hour),
+			},
+			wantTimestamp: timestamp(1 * time.Hour),
+		},
+		{
+			name: "only janitor writes",
+			managedFields: []metav1.ManagedFieldsEntry{
+				managedField(KubeFieldManager, "", 5*time.Hour),
+			},
+			wantTimestamp: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			resource := unstructured.Unstructured{}
+			resource.SetManagedFields(testCase.managedFields)
+
+			got := resourceTimestampFromManagedFields(resource)
+			switch {
+			case got == nil && testCase.wantTimestamp == nil:
+			case got == nil || testCase.wantTimestamp == nil || !got.Equal(*testCase.wantTimestamp):
+				t.Fatalf("timestamp: got %v, want %v", got, testCase.wantTimestamp)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package kube_janitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	TracingExporterOtlp   = "otlp"
+	TracingExporterStdout = "stdout"
+
+	TracingName = "github.com/webdevops/kube-janitor"
+
+	TracingAttrCluster   = attribute.Key("janitor.cluster")
+	TracingAttrRule      = attribute.Key("janitor.rule")
+	TracingAttrGvr       = attribute.Key("janitor.groupVersionResource")
+	TracingAttrNamespace = attribute.Key("janitor.namespace")
+	TracingAttrName      = attribute.Key("janitor.name")
+	TracingAttrItems     = attribute.Key("janitor.items")
+	TracingAttrPages     = attribute.Key("janitor.pages")
+	TracingAttrDryRun    = attribute.Key("janitor.dryRun")
+
+	TracingAttrFailedGroups = attribute.Key("janitor.failedGroups")
+
+	TracingAttrNamespaceCount    = attribute.Key("janitor.namespaces")
+	TracingAttrResourceTypeCount = attribute.Key("janitor.resourceTypes")
+)
+
+// NewTracerProvider creates the OpenTelemetry tracer provider with the OTLP (http) or stdout exporter
+// and registers it globally, the OTLP endpoint defaults to the OTEL_EXPORTER_OTLP_* env vars if empty
+func NewTracerProvider(ctx context.Context, exporterType, endpoint string, insecure bool, serviceVersion string) (*sdktrace.TracerProvider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch exporterType {
+	case TracingExporterOtlp:
+		opts := []otlptracehttp.Option{}
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf(`tracing exporter "%v" is not supported`, exporterType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("kube-janitor"),
+			semconv.ServiceVersion(serviceVersion),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider, nil
+}
+
+// startSpan starts a new span (noop if no tracer provider is set)
+func (j *Janitor) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := j.tracer
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer(TracingName)
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// traceDelete wraps a delete API call with a span
+func (j *Janitor) traceDelete(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string, deleteFunc func(ctx context.Context) error) error {
+	ctx, span := j.startSpan(
+		ctx,
+		"kube.delete",
+		TracingAttrGvr.String(fmt.Sprintf("%s/%s/%s", gvr.Group, gvr.Version, gvr.Resource)),
+		TracingAttrNamespace.String(namespace),
+		TracingAttrName.String(name),
+	)
+	defer span.End()
+
+	err := deleteFunc(ctx)
+	spanRecordError(span, err)
+	return err
+}
+
+// spanRecordError marks the span as failed if err is set
+func spanRecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
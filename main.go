@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/webdevops/kube-janitor/config"
 	"github.com/webdevops/kube-janitor/kube_janitor"
@@ -33,26 +37,145 @@ func main() {
 
 	initSystem()
 
-	janitor := kube_janitor.New()
-	janitor.SetKubeconfig(Opts.Kubernetes.Config).
-		SetLogger(logger).
-		Connect().
-		SetKubePageSize(Opts.Kubernetes.ItemsPerPage).
-		LoadConfigFromFile(Opts.Janitor.Config).
-		SetDryRun(Opts.Janitor.DryRun)
+	var tracerProvider *sdktrace.TracerProvider
+	if Opts.Tracing.Exporter != "" {
+		// both would be written to stdout and the audit stream would not be parseable anymore
+		if Opts.Tracing.Exporter == kube_janitor.TracingExporterStdout && Opts.Audit.Stdout {
+			logger.Fatal("tracing exporter stdout cannot be combined with --audit.stdout, use --audit.file.path or the otlp exporter")
+		}
 
-	if Opts.Janitor.Once {
-		err := janitor.Run()
+		var err error
+		tracerProvider, err = kube_janitor.NewTracerProvider(context.Background(), Opts.Tracing.Exporter, Opts.Tracing.OtlpEndpoint, Opts.Tracing.OtlpInsecure, gitTag)
+		if err != nil {
+			logger.Fatal("unable to setup tracing", slog.Any("error", err))
+		}
+		defer tracerProvider.Shutdown(context.Background()) // nolint:errcheck
+	}
+
+	var boltStateBackend *kube_janitor.StateBackendBoltDBStore
+	if Opts.State.Backend == kube_janitor.StateBackendBoltDB {
+		var err error
+		boltStateBackend, err = kube_janitor.NewStateBackendBoltDB(Opts.State.BoltDBPath)
 		if err != nil {
-			logger.Error(err.Error())
+			logger.Fatal("unable to open BoltDB state file", slog.String("path", Opts.State.BoltDBPath), slog.Any("error", err))
+		}
+		defer boltStateBackend.Close() // nolint:errcheck
+	}
+
+	var auditLogger *kube_janitor.AuditLogger
+	if Opts.Audit.Stdout || Opts.Audit.FilePath != "" {
+		auditWriters := []io.Writer{}
+		if Opts.Audit.Stdout {
+			auditWriters = append(auditWriters, os.Stdout)
+		}
+		if Opts.Audit.FilePath != "" {
+			auditFile := kube_janitor.NewAuditFileWriter(Opts.Audit.FilePath, Opts.Audit.FileMaxSize, Opts.Audit.FileMaxBackups)
+			defer auditFile.Close() // nolint:errcheck
+			auditWriters = append(auditWriters, auditFile)
+		}
+
+		auditLogger = kube_janitor.NewAuditLogger(Opts.Audit.HashChain, auditWriters...)
+		if Opts.Audit.HashChain && Opts.Audit.FilePath != "" {
+			if err := auditLogger.RestoreHashChain(Opts.Audit.FilePath); err != nil {
+				logger.Fatal("unable to restore audit hash chain", slog.String("path", Opts.Audit.FilePath), slog.Any("error", err))
+			}
+		}
+	}
+
+	clusters, err := kube_janitor.DiscoverKubeClusters(Opts.Kubernetes.Config, Opts.Kubernetes.Contexts, Opts.Kubernetes.ConfigDir)
+	if err != nil {
+		logger.Fatal("unable to build cluster list", slog.Any("error", err))
+	}
+
+	janitors := []*kube_janitor.Janitor{}
+	if len(clusters) == 0 {
+		// single cluster mode
+		janitor := kube_janitor.New()
+		if tracerProvider != nil {
+			janitor.SetTracerProvider(tracerProvider)
+		}
+		janitor.SetKubeconfig(Opts.Kubernetes.Config).
+			SetLogger(logger).
+			Connect()
+		setupJanitor(janitor, "", boltStateBackend, auditLogger)
+		janitors = append(janitors, janitor)
+	} else {
+		// multi-cluster mode, clusters are connected on the first run
+		clusterNames := []string{}
+		for _, cluster := range clusters {
+			clusterNames = append(clusterNames, cluster.Name)
+		}
+
+		for _, cluster := range clusters {
+			logger.Info("adding cluster", slog.String("cluster", cluster.Name), slog.String("kubeconfig", cluster.Kubeconfig), slog.String("context", cluster.Context))
+
+			janitor := kube_janitor.NewForCluster(cluster.Name)
+			if tracerProvider != nil {
+				janitor.SetTracerProvider(tracerProvider)
+			}
+			janitor.SetKubeconfig(cluster.Kubeconfig).
+				SetKubeContext(cluster.Context).
+				SetClusterNames(clusterNames).
+				SetLogger(logger.With(slog.String("cluster", cluster.Name)))
+			setupJanitor(janitor, cluster.Name, boltStateBackend, auditLogger)
+			janitors = append(janitors, janitor)
+		}
+	}
+
+	if Opts.Janitor.Once {
+		failed := false
+		for _, janitor := range janitors {
+			if err := janitor.RunIsolated(); err != nil {
+				logger.Error(err.Error())
+				failed = true
+			}
+		}
+		if failed {
 			os.Exit(1)
 		}
 		logger.Info("finished once run, existing")
 	} else {
-		janitor.Start(Opts.Janitor.Interval)
+		for _, janitor := range janitors {
+			janitor.Start(Opts.Janitor.Interval)
+		}
 
 		logger.Info("starting http server", slog.String("bind", Opts.Server.Bind))
-		startHttpServer()
+		startHttpServer(janitors)
+	}
+}
+
+// setupJanitor applies the configuration and the state, report and audit backends to the janitor
+func setupJanitor(janitor *kube_janitor.Janitor, cluster string, boltStateBackend *kube_janitor.StateBackendBoltDBStore, auditLogger *kube_janitor.AuditLogger) {
+	janitor.SetKubePageSize(Opts.Kubernetes.ItemsPerPage).
+		SetKubeDiscoveryRefresh(Opts.Kubernetes.DiscoveryRefresh).
+		LoadConfigFromFile(Opts.Janitor.Config).
+		SetDryRun(Opts.Janitor.DryRun).
+		SetMetricsMode(Opts.Metrics.Mode, Opts.Metrics.Namespaces).
+		SetHealthIntervalMultiplier(Opts.Server.HealthIntervalMultiplier)
+
+	switch Opts.State.Backend {
+	case kube_janitor.StateBackendBoltDB:
+		if cluster != "" {
+			janitor.SetStateBackend(boltStateBackend.ForCluster(cluster))
+		} else {
+			janitor.SetStateBackend(boltStateBackend)
+		}
+	case kube_janitor.StateBackendConfigMap:
+		janitor.SetStateBackend(kube_janitor.NewStateBackendConfigMap(Opts.State.ConfigMapNamespace, Opts.State.ConfigMapName))
+	case kube_janitor.StateBackendLease:
+		janitor.SetStateBackend(kube_janitor.NewStateBackendLease(Opts.State.LeaseNamespace, Opts.State.LeaseName))
+	}
+
+	reportRetention := kube_janitor.ReportRetention{MaxAge: Opts.Report.MaxAge, Keep: Opts.Report.Keep}
+	switch Opts.Report.Backend {
+	case kube_janitor.ReportBackendFile:
+		janitor.SetReportBackend(kube_janitor.NewReportBackendFile(kube_janitor.ClusterFilePath(Opts.Report.FilePath, cluster)), reportRetention)
+	case kube_janitor.ReportBackendConfigMap:
+		janitor.SetReportBackend(kube_janitor.NewReportBackendConfigMap(Opts.Report.ConfigMapNamespace, Opts.Report.ConfigMapName), reportRetention)
+	}
+
+	if auditLogger != nil {
+		janitor.SetAuditLogger(auditLogger)
 	}
 }
 
@@ -75,21 +198,46 @@ func initArgparser() {
 }
 
 // startHttpServer start and handle prometheus handler
-func startHttpServer() {
+func startHttpServer(janitors []*kube_janitor.Janitor) {
 	mux := http.NewServeMux()
 
-	// healthz
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if _, err := fmt.Fprint(w, "Ok"); err != nil {
-			logger.Error(err.Error())
+	livenessChecks := func() []kube_janitor.HealthCheck {
+		checks := []kube_janitor.HealthCheck{}
+		for _, janitor := range janitors {
+			checks = append(checks, janitor.LivenessChecks()...)
+		}
+		return checks
+	}
+
+	readinessChecks := func(r *http.Request) []kube_janitor.HealthCheck {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		checks := []kube_janitor.HealthCheck{}
+		for _, janitor := range janitors {
+			checks = append(checks, janitor.ReadinessChecks(ctx)...)
 		}
+		return checks
+	}
+
+	// healthz (liveness: run loop is not stuck)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthResponse(w, livenessChecks(), r.URL.Query().Has("verbose"))
 	})
 
-	// readyz
+	// readyz (config is loaded and api server is reachable)
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-		if _, err := fmt.Fprint(w, "Ok"); err != nil {
-			logger.Error(err.Error())
+		writeHealthResponse(w, readinessChecks(r), r.URL.Query().Has("verbose"))
+	})
+
+	// livez (details of all checks, fails like healthz)
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		checks := livenessChecks()
+		for _, check := range readinessChecks(r) {
+			check.Optional = true
+			checks = append(checks, check)
 		}
+		writeHealthResponse(w, checks, true)
 	})
 
 	mux.Handle("/metrics", promhttp.Handler())
@@ -104,3 +252,36 @@ func startHttpServer() {
 		logger.Fatal(err.Error())
 	}
 }
+
+// writeHealthResponse writes the result of the health checks (500 if one check failed), verbose lists every check
+func writeHealthResponse(w http.ResponseWriter, checks []kube_janitor.HealthCheck, verbose bool) {
+	failed := false
+	response := ""
+	for _, check := range checks {
+		switch {
+		case check.Error == nil:
+			response += fmt.Sprintf("[+] %s ok\n", check.Name)
+		case check.Optional:
+			response += fmt.Sprintf("[!] %s failed (optional): %v\n", check.Name, check.Error)
+		default:
+			response += fmt.Sprintf("[-] %s failed: %v\n", check.Name, check.Error)
+		}
+
+		if check.Failed() {
+			failed = true
+		}
+	}
+
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+		verbose = true
+	}
+
+	if !verbose {
+		response = "Ok"
+	}
+
+	if _, err := fmt.Fprint(w, response); err != nil {
+		logger.Error(err.Error())
+	}
+}